@@ -0,0 +1,64 @@
+package vals
+
+import (
+	"github.com/kroonprins/vals/pkg/diskcache"
+)
+
+// Cache is the interface of the caches of the values and the documents obtained from providers.
+// Both *lru.Cache from github.com/hashicorp/golang-lru and *diskcache.Cache implement it.
+type Cache interface {
+	Get(key interface{}) (value interface{}, ok bool)
+	Add(key, value interface{}) (evicted bool)
+	Remove(key interface{}) (present bool)
+	Keys() []interface{}
+}
+
+// tieredCache is an in-memory cache backed by a persistent one.
+// Values found only in the persistent cache are promoted to the in-memory one.
+type tieredCache struct {
+	mem  Cache
+	disk Cache
+}
+
+func newTieredCache(mem Cache, disk *diskcache.Cache, namespace string) Cache {
+	if disk == nil {
+		return mem
+	}
+	return &tieredCache{mem: mem, disk: disk.WithNamespace(namespace)}
+}
+
+func (c *tieredCache) Get(key interface{}) (interface{}, bool) {
+	if v, ok := c.mem.Get(key); ok {
+		return v, true
+	}
+	v, ok := c.disk.Get(key)
+	if ok {
+		c.mem.Add(key, v)
+	}
+	return v, ok
+}
+
+func (c *tieredCache) Add(key, value interface{}) bool {
+	c.disk.Add(key, value)
+	return c.mem.Add(key, value)
+}
+
+func (c *tieredCache) Remove(key interface{}) bool {
+	onDisk := c.disk.Remove(key)
+	return c.mem.Remove(key) || onDisk
+}
+
+// Keys returns the keys of both caches, which contain the keys of the entries not promoted to the in-memory cache yet
+func (c *tieredCache) Keys() []interface{} {
+	seen := map[interface{}]bool{}
+	var keys []interface{}
+	for _, cache := range []Cache{c.mem, c.disk} {
+		for _, k := range cache.Keys() {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
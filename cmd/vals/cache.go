@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kroonprins/vals/pkg/diskcache"
+)
+
+type cacheFlags struct {
+	enabled      *bool
+	dir          *string
+	ttl          *time.Duration
+	providerTTLs *string
+}
+
+// addCacheFlags adds the flags to configure the on-disk cache to the flag set
+func addCacheFlags(fs *flag.FlagSet) *cacheFlags {
+	defaultDir, _ := diskcache.DefaultDir()
+	if d := os.Getenv("VALS_CACHE_DIR"); d != "" {
+		defaultDir = d
+	}
+
+	return &cacheFlags{
+		enabled: fs.Bool("disk-cache", os.Getenv("VALS_DISK_CACHE") == "true", "Persist the values obtained from providers in an encrypted on-disk cache, keyed by $"+diskcache.EnvKeyFile+" or $"+diskcache.EnvKey+". Defaults to true when $VALS_DISK_CACHE is \"true\""),
+		dir:     fs.String("cache-dir", defaultDir, "Directory of the on-disk cache. Defaults to $VALS_CACHE_DIR if set"),
+		ttl:     fs.Duration("cache-ttl", diskcache.DefaultTTL, "How long the values are kept in the on-disk cache"),
+		providerTTLs: fs.String("cache-ttl-for", "", "Comma-separated list of per-provider TTLs that override --cache-ttl, e.g. \"vault=5m,awsssm=24h\". "+
+			"A negative TTL disables the on-disk cache for the provider"),
+	}
+}
+
+// diskCacheOrFail returns the on-disk cache, or nil if it isn't enabled
+func (f *cacheFlags) diskCacheOrFail() *diskcache.Cache {
+	if !*f.enabled {
+		return nil
+	}
+
+	providerTTLs, err := parseProviderTTLs(*f.providerTTLs)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	c, err := diskcache.New(diskcache.Options{
+		Dir:          *f.dir,
+		TTL:          *f.ttl,
+		ProviderTTLs: providerTTLs,
+	})
+	if err != nil {
+		fatal("%v", err)
+	}
+	return c
+}
+
+func parseProviderTTLs(s string) (map[string]time.Duration, error) {
+	ttls := map[string]time.Duration{}
+	if s == "" {
+		return ttls, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		provider, ttl, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --cache-ttl-for entry %q: expected PROVIDER=DURATION", kv)
+		}
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --cache-ttl-for entry %q: %v", kv, err)
+		}
+		ttls[provider] = d
+	}
+	return ttls, nil
+}
+
+func cacheUsage() {
+	fmt.Fprint(os.Stderr, `Usage:
+  vals cache [command]
+
+Available Commands:
+  ls		List the entries of the on-disk cache
+  clear		Remove the entries of the on-disk cache
+`)
+}
+
+// runCache runs `vals cache ls` and `vals cache clear`
+func runCache(args []string) {
+	if len(args) == 0 {
+		cacheUsage()
+		os.Exit(1)
+	}
+
+	cmd := flag.NewFlagSet("cache "+args[0], flag.ExitOnError)
+	dir := cmd.String("cache-dir", "", "Directory of the on-disk cache. Defaults to $VALS_CACHE_DIR if set")
+
+	newCache := func() *diskcache.Cache {
+		d := *dir
+		if d == "" {
+			d = os.Getenv("VALS_CACHE_DIR")
+		}
+		c, err := diskcache.New(diskcache.Options{Dir: d})
+		if err != nil {
+			fatal("%v", err)
+		}
+		return c
+	}
+
+	switch args[0] {
+	case "ls":
+		cmd.Parse(args[1:])
+
+		entries, err := newCache().Entries()
+		if err != nil {
+			fatal("%v", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tPROVIDER\tKIND\tEXPIRES")
+		now := time.Now()
+		for _, e := range entries {
+			expires := e.ExpiresAt.Format(time.RFC3339)
+			if !now.Before(e.ExpiresAt) {
+				expires += " (expired)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Key, e.Provider, e.Namespace, expires)
+		}
+		w.Flush()
+	case "clear":
+		expired := cmd.Bool("expired", false, "Remove only the expired entries, and the entries that cannot be decrypted with the current key")
+		cmd.Parse(args[1:])
+
+		n, err := newCache().Clear(*expired)
+		if err != nil {
+			fatal("%v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Removed %d entries\n", n)
+	default:
+		cacheUsage()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kroonprins/vals"
+)
+
+// runDiff runs `vals diff`, which reports the YAML paths whose values changed between the evaluated template
+// and a previous render or a hash manifest, showing the values only as prefixes of their keyed hashes
+func runDiff(args []string) {
+	cmd := flag.NewFlagSet("diff", flag.ExitOnError)
+	f := cmd.String("f", "-", "YAML/JSON file to be evaluated. When set to \"-\", vals reads from STDIN")
+	against := cmd.String("against", "", "Previously rendered YAML/JSON file, or hash manifest written with --write-manifest, to compare the evaluated file against")
+	writeManifest := cmd.String("write-manifest", "", "Write the hash manifest of the evaluated file to the path, to be compared against later")
+	o := cmd.String("o", "text", "Output type which is either \"text\" or \"json\"")
+	exitCode := cmd.Bool("exit-code", false, "Exit with 1 when there are changes, and with 0 otherwise")
+	timeout := cmd.Duration("timeout", 0, "Give up evaluating refs after the duration, e.g. \"30s\". Zero means no timeout")
+	concurrency := cmd.Int("concurrency", 0, "Maximum number of refs resolved in parallel. Defaults to 8 when zero")
+	cache := addCacheFlags(cmd)
+	cmd.Parse(args)
+
+	if *against == "" && *writeManifest == "" {
+		fatal("either --against or --write-manifest is required")
+	}
+
+	var (
+		old *vals.HashManifest
+		key []byte
+	)
+	if *against != "" {
+		nodes := readNodesOrFail(against)
+
+		m, ok, err := vals.ParseHashManifest(nodes)
+		if err != nil {
+			fatal("%v", err)
+		}
+		if ok {
+			old = m
+		} else {
+			if *writeManifest == "" {
+				// The hashes of both sides are only compared to each other, so that any key will do
+				key = randomKey()
+			} else {
+				key = hashKeyOrFail()
+			}
+			old, err = vals.NewHashManifest(nodes, key)
+			if err != nil {
+				fatal("%v", err)
+			}
+		}
+	}
+	if key == nil {
+		key = hashKeyOrFail()
+	}
+
+	nodes := readNodesOrFail(f)
+
+	ctx, cancel := contextWithTimeout(*timeout)
+	defer cancel()
+
+	runtime, err := vals.New(vals.Options{Concurrency: *concurrency, DiskCache: cache.diskCacheOrFail()})
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	rendered, err := runtime.EvalNodes(ctx, nodes)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	current, err := vals.NewHashManifest(rendered, key)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if *writeManifest != "" {
+		bs, err := json.MarshalIndent(current, "", "  ")
+		if err != nil {
+			fatal("%v", err)
+		}
+		if err := os.WriteFile(*writeManifest, append(bs, '\n'), 0644); err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	if old == nil {
+		return
+	}
+
+	changes, err := vals.DiffHashManifests(old, current)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if err := writeChanges(os.Stdout, *o, changes); err != nil {
+		fatal("%v", err)
+	}
+
+	if *exitCode && len(changes) > 0 {
+		os.Exit(1)
+	}
+}
+
+// hashKeyOrFail returns the key of the hashes of the hash manifests that are read or written by `vals diff`,
+// which is never stored in the manifests
+func hashKeyOrFail() []byte {
+	key, err := vals.HashKeyFromEnv()
+	if err != nil {
+		fatal("hash manifests require a key: %v", err)
+	}
+	return key
+}
+
+func randomKey() []byte {
+	bs := make([]byte, 32)
+	if _, err := rand.Read(bs); err != nil {
+		fatal("generating hash key: %v", err)
+	}
+	return bs
+}
+
+// writeChanges writes the changes found by `vals diff` in either the "text" or the "json" format
+func writeChanges(w io.Writer, format string, changes []vals.Change) error {
+	switch format {
+	case "json":
+		if changes == nil {
+			changes = []vals.Change{}
+		}
+		bs, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(bs))
+	case "text":
+		for _, c := range changes {
+			path := c.Path
+			if path == "" {
+				path = "."
+			}
+			switch c.Type {
+			case vals.ChangeAdded:
+				fmt.Fprintf(w, "+ %s (document %d): %s\n", path, c.Document, c.NewHash)
+			case vals.ChangeRemoved:
+				fmt.Fprintf(w, "- %s (document %d): %s\n", path, c.Document, c.OldHash)
+			default:
+				fmt.Fprintf(w, "~ %s (document %d): %s -> %s\n", path, c.Document, c.OldHash, c.NewHash)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported output format %q: expected either \"text\" or \"json\"", format)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kroonprins/vals"
+)
+
+// runHelmPostRender runs `vals helm-post-render`, which follows the contract of Helm's --post-renderer:
+// it reads the rendered manifests from STDIN and writes the manifests with the refs evaluated to STDOUT
+func runHelmPostRender(args []string) {
+	cmd := flag.NewFlagSet("helm-post-render", flag.ExitOnError)
+	e := cmd.Bool("exclude-secret", false, "Leave secretref+<uri> as-is and only replace ref+<uri>")
+	timeout := cmd.Duration("timeout", 0, "Give up evaluating refs after the duration, e.g. \"30s\". Zero means no timeout")
+	concurrency := cmd.Int("concurrency", 0, "Maximum number of refs resolved in parallel. Defaults to 8 when zero")
+	cache := addCacheFlags(cmd)
+	cmd.Parse(args)
+
+	ctx, cancel := contextWithTimeout(*timeout)
+	defer cancel()
+
+	runtime, err := vals.New(vals.Options{
+		ExcludeSecret: *e,
+		Concurrency:   *concurrency,
+		KeepGoing:     true,
+		DiskCache:     cache.diskCacheOrFail(),
+	})
+	if err != nil {
+		fatal("vals helm-post-render: %v", err)
+	}
+
+	if err := helmPostRender(ctx, runtime, os.Stdin, os.Stdout); err != nil {
+		fatal("vals helm-post-render: %v", err)
+	}
+}
+
+// helmPostRender evaluates the refs in the manifests read from r, including the ones in the base64-encoded data of Secrets
+// and the binaryData of ConfigMaps, and writes the manifests to w.
+// Nothing is written when anything fails, so that Helm never applies partial manifests.
+func helmPostRender(ctx context.Context, runtime *vals.Runtime, r io.Reader, w io.Writer) error {
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("reading manifests: %w", err)
+	}
+
+	var nodes []yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(bs))
+	for {
+		var node yaml.Node
+		if err := decoder.Decode(&node); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("decoding manifests: %w", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	binaryKeys := ksBinaryKeys{}
+	for i := range nodes {
+		decoded, err := ksDecode(nodes[i], i, binaryKeys)
+		if err != nil {
+			return fmt.Errorf("document %d: decoding secret data: %w", i, err)
+		}
+		nodes[i] = *decoded
+	}
+
+	if err := runtime.EvalNodesInPlace(ctx, nodes); err != nil {
+		return err
+	}
+
+	for i := range nodes {
+		if err := ksEncode(&nodes[i], i, binaryKeys, nil); err != nil {
+			return fmt.Errorf("document %d: encoding secret data: %w", i, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := vals.OutputNodes(&buf, "yaml", nodes); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("writing manifests: %w", err)
+	}
+
+	return nil
+}
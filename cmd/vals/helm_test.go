@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kroonprins/vals"
+)
+
+func TestHelmPostRender(t *testing.T) {
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	in := fmt.Sprintf(`---
+# Source: app/templates/secret.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+data:
+  password: %s
+  plain: %s
+---
+# Source: app/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  host: ref+echo://db.example.com
+`, b64("ref+echo://s3cr3t"), b64("as-is"))
+
+	expected := fmt.Sprintf(`# Source: app/templates/secret.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+data:
+  password: %s
+  plain: %s
+---
+# Source: app/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  host: db.example.com
+`, b64("s3cr3t"), b64("as-is"))
+
+	runtime, err := vals.New(vals.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := helmPostRender(context.Background(), runtime, strings.NewReader(in), &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("unexpected out: expected=%s, got=%s", expected, out.String())
+	}
+}
+
+func TestHelmPostRender_Error(t *testing.T) {
+	in := `apiVersion: v1
+kind: ConfigMap
+data:
+  a: ref+file:///nonexistent/a.yaml#/a
+  b: ref+echo://b
+`
+
+	runtime, err := vals.New(vals.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = helmPostRender(context.Background(), runtime, strings.NewReader(in), &out)
+	if err == nil || !strings.Contains(err.Error(), "data.a (document 0, line 4, column 6)") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("expected nothing to be written on failures, got %s", out.String())
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ksResource identifies a Kubernetes resource in the YAML documents
+type ksResource struct {
+	Document int
+	// Item is the index of the resource in the items of the List in the document, or -1 when the document is the resource
+	Item int
+}
+
+// ksBinaryKeys is the keys of the ConfigMaps' "binaryData" that ksDecode moved to "data", by the ConfigMaps,
+// so that ksEncode moves them back
+type ksBinaryKeys map[ksResource][]string
+
+// KsDecode converts the base64-encoded "data" of the Secret resources in the YAML document to "stringData",
+// so that the refs in them can be evaluated.
+// The resources in the items of Lists like `kind: List` are converted as well.
+// Entries that aren't valid UTF-8 once decoded, like images, are left encoded.
+//
+// ConfigMaps are left as is, as KsEncode couldn't tell which of their "data" came from "binaryData".
+func KsDecode(node yaml.Node) (*yaml.Node, error) {
+	return ksDecode(node, 0, nil)
+}
+
+// KsEncode converts the "stringData" of the Secret resources in the YAML document back to base64-encoded "data",
+// merging them into the existing "data", where "stringData" takes precedence as it does in Kubernetes.
+// The resources in the items of Lists like `kind: List` are converted as well.
+//
+// ConfigMaps are left as is, like KsDecode does.
+func KsEncode(node *yaml.Node) error {
+	return ksEncode(node, 0, nil, nil)
+}
+
+// ksDecode is KsDecode that also moves the "binaryData" of the ConfigMaps to "data" when binaryKeys isn't nil,
+// recording the moved keys into binaryKeys so that ksEncode moves them back
+func ksDecode(node yaml.Node, doc int, binaryKeys ksBinaryKeys) (*yaml.Node, error) {
+	if node.Kind != yaml.DocumentNode {
+		return nil, fmt.Errorf("unexpected kind of node: expected %d, got %d", yaml.DocumentNode, node.Kind)
+	}
+
+	res := node
+	if len(res.Content) == 0 {
+		return &res, nil
+	}
+
+	err := ksResources(res.Content[0], func(item int, r *yaml.Node) error {
+		switch ksKind(r) {
+		case "Secret":
+			return ksDecodeSecret(r)
+		case "ConfigMap":
+			if binaryKeys == nil {
+				return nil
+			}
+			moved, err := ksDecodeConfigMap(r)
+			if err != nil {
+				return err
+			}
+			if len(moved) > 0 {
+				binaryKeys[ksResource{Document: doc, Item: item}] = moved
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// ksEncode is KsEncode that moves the keys of the ConfigMaps' "data" recorded by ksDecode back to "binaryData".
+// The node is either a document or its root mapping, as returned by vals.Runtime.EvalNodes.
+// The values are passed to redact, when not nil, before being encoded, as the values can't be redacted once encoded.
+func ksEncode(node *yaml.Node, doc int, binaryKeys ksBinaryKeys, redact func(string) string) error {
+	root := node
+	if root.Kind == yaml.DocumentNode {
+		if len(root.Content) == 0 {
+			return nil
+		}
+		root = root.Content[0]
+	}
+
+	return ksResources(root, func(item int, r *yaml.Node) error {
+		switch ksKind(r) {
+		case "Secret":
+			return ksEncodeSecret(r, redact)
+		case "ConfigMap":
+			return ksEncodeConfigMap(r, binaryKeys[ksResource{Document: doc, Item: item}], redact)
+		}
+		return nil
+	})
+}
+
+// ksResources calls f with the resource, or with each item of the resource when it's a List like `kind: List` or `kind: SecretList`
+func ksResources(root *yaml.Node, f func(item int, r *yaml.Node) error) error {
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	if strings.HasSuffix(ksKind(root), "List") {
+		if i := mappingIndex(root, "items"); i >= 0 && root.Content[i+1].Kind == yaml.SequenceNode {
+			for j, it := range root.Content[i+1].Content {
+				if it.Kind != yaml.MappingNode {
+					continue
+				}
+				if err := f(j, it); err != nil {
+					return fmt.Errorf("items[%d]: %w", j, err)
+				}
+			}
+			return nil
+		}
+	}
+
+	return f(-1, root)
+}
+
+func ksKind(r *yaml.Node) string {
+	if i := mappingIndex(r, "kind"); i >= 0 {
+		return r.Content[i+1].Value
+	}
+	return ""
+}
+
+// ksDecodeSecret moves the decoded entries of the "data" of the Secret to "stringData"
+func ksDecodeSecret(r *yaml.Node) error {
+	data := mappingIndex(r, "data")
+	if data < 0 || r.Content[data+1].Kind != yaml.MappingNode {
+		return nil
+	}
+
+	kept, decoded, err := ksDecodeEntries(r.Content[data+1], "data", nil)
+	if err != nil {
+		return err
+	}
+	if len(decoded) == 0 {
+		return nil
+	}
+
+	stringData := mappingIndex(r, "stringData")
+	switch {
+	case stringData >= 0 && r.Content[stringData+1].Kind == yaml.MappingNode:
+		// stringData takes precedence over data in Kubernetes
+		existing := r.Content[stringData+1]
+		for i := 0; i+1 < len(decoded); i += 2 {
+			if mappingIndex(existing, decoded[i].Value) < 0 {
+				existing.Content = append(existing.Content, decoded[i], decoded[i+1])
+			}
+		}
+	case len(kept) == 0:
+		// The key is renamed in place, keeping the order of the keys of the resource
+		k := *r.Content[data]
+		k.Value = "stringData"
+		v := *r.Content[data+1]
+		v.Content = decoded
+		r.Content[data], r.Content[data+1] = &k, &v
+		return nil
+	default:
+		k := *r.Content[data]
+		k.Value = "stringData"
+		v := *r.Content[data+1]
+		v.Content = decoded
+		r.Content = append(r.Content[:data+2], append([]*yaml.Node{&k, &v}, r.Content[data+2:]...)...)
+	}
+
+	setMappingContent(r, data, kept)
+
+	return nil
+}
+
+// ksDecodeConfigMap moves the decoded entries of the "binaryData" of the ConfigMap to "data", and returns their keys
+func ksDecodeConfigMap(r *yaml.Node) ([]string, error) {
+	binaryData := mappingIndex(r, "binaryData")
+	if binaryData < 0 || r.Content[binaryData+1].Kind != yaml.MappingNode {
+		return nil, nil
+	}
+
+	data := mappingIndex(r, "data")
+	var existing *yaml.Node
+	if data >= 0 && r.Content[data+1].Kind == yaml.MappingNode {
+		existing = r.Content[data+1]
+	}
+
+	kept, decoded, err := ksDecodeEntries(r.Content[binaryData+1], "binaryData", existing)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) == 0 {
+		return nil, nil
+	}
+
+	var moved []string
+	for i := 0; i+1 < len(decoded); i += 2 {
+		moved = append(moved, decoded[i].Value)
+	}
+
+	if existing != nil {
+		existing.Content = append(existing.Content, decoded...)
+	} else {
+		k := *r.Content[binaryData]
+		k.Value = "data"
+		v := *r.Content[binaryData+1]
+		v.Content = decoded
+		if data >= 0 {
+			// e.g. `data: null`
+			r.Content[data], r.Content[data+1] = &k, &v
+		} else {
+			r.Content = append(r.Content[:binaryData], append([]*yaml.Node{&k, &v}, r.Content[binaryData:]...)...)
+		}
+	}
+
+	setMappingContent(r, mappingIndex(r, "binaryData"), kept)
+
+	return moved, nil
+}
+
+// ksDecodeEntries decodes the base64-encoded values of the mapping, and returns the entries that are left encoded
+// and the decoded ones. Entries are left encoded when they aren't valid UTF-8 once decoded, or when their keys exist in conflicts.
+func ksDecodeEntries(m *yaml.Node, field string, conflicts *yaml.Node) (kept, decoded []*yaml.Node, err error) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		k, v := m.Content[i], m.Content[i+1]
+		bs, err := base64.StdEncoding.DecodeString(v.Value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s.%s: %w", field, k.Value, err)
+		}
+		if !utf8.Valid(bs) || conflicts != nil && mappingIndex(conflicts, k.Value) >= 0 {
+			kept = append(kept, k, v)
+			continue
+		}
+		d := *v
+		d.Value = string(bs)
+		d.Tag = "!!str"
+		d.Style = 0
+		decoded = append(decoded, k, &d)
+	}
+	return kept, decoded, nil
+}
+
+// ksEncodeSecret moves the encoded entries of the "stringData" of the Secret to "data"
+func ksEncodeSecret(r *yaml.Node, redact func(string) string) error {
+	stringData := mappingIndex(r, "stringData")
+	if stringData < 0 || r.Content[stringData+1].Kind != yaml.MappingNode {
+		return nil
+	}
+
+	encoded, err := ksEncodeEntries(r.Content[stringData+1], "stringData", redact)
+	if err != nil {
+		return err
+	}
+
+	data := mappingIndex(r, "data")
+	if data < 0 || r.Content[data+1].Kind != yaml.MappingNode {
+		k := *r.Content[stringData]
+		k.Value = "data"
+		v := *r.Content[stringData+1]
+		v.Content = encoded
+		r.Content[stringData], r.Content[stringData+1] = &k, &v
+		if data >= 0 {
+			// e.g. `data: null`, which is replaced with the encoded entries
+			r.Content = append(r.Content[:data], r.Content[data+2:]...)
+		}
+		return nil
+	}
+
+	existing := r.Content[data+1]
+	for i := 0; i+1 < len(encoded); i += 2 {
+		if j := mappingIndex(existing, encoded[i].Value); j >= 0 {
+			existing.Content[j+1] = encoded[i+1]
+		} else {
+			existing.Content = append(existing.Content, encoded[i], encoded[i+1])
+		}
+	}
+	r.Content = append(r.Content[:stringData], r.Content[stringData+2:]...)
+
+	return nil
+}
+
+// ksEncodeConfigMap moves the entries of the "data" of the ConfigMap with the keys back to "binaryData", encoded
+func ksEncodeConfigMap(r *yaml.Node, keys []string, redact func(string) string) error {
+	data := mappingIndex(r, "data")
+	if len(keys) == 0 || data < 0 || r.Content[data+1].Kind != yaml.MappingNode {
+		return nil
+	}
+
+	only := map[string]bool{}
+	for _, k := range keys {
+		only[k] = true
+	}
+
+	var kept, moving []*yaml.Node
+	m := r.Content[data+1]
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if only[m.Content[i].Value] {
+			moving = append(moving, m.Content[i], m.Content[i+1])
+		} else {
+			kept = append(kept, m.Content[i], m.Content[i+1])
+		}
+	}
+	if len(moving) == 0 {
+		return nil
+	}
+
+	encoded, err := ksEncodeEntries(&yaml.Node{Kind: yaml.MappingNode, Content: moving}, "data", redact)
+	if err != nil {
+		return err
+	}
+
+	binaryData := mappingIndex(r, "binaryData")
+	switch {
+	case binaryData >= 0 && r.Content[binaryData+1].Kind == yaml.MappingNode:
+		r.Content[binaryData+1].Content = append(r.Content[binaryData+1].Content, encoded...)
+	case len(kept) == 0 && binaryData < 0:
+		// The key is renamed in place, keeping the order of the keys of the resource
+		k := *r.Content[data]
+		k.Value = "binaryData"
+		v := *m
+		v.Content = encoded
+		r.Content[data], r.Content[data+1] = &k, &v
+		return nil
+	default:
+		r.Content = append(r.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "binaryData"},
+			&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: encoded},
+		)
+	}
+
+	setMappingContent(r, mappingIndex(r, "data"), kept)
+
+	return nil
+}
+
+// ksEncodeEntries returns the entries of the mapping with the values encoded in base64
+func ksEncodeEntries(m *yaml.Node, field string, redact func(string) string) ([]*yaml.Node, error) {
+	encoded := make([]*yaml.Node, 0, len(m.Content))
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		k, v := m.Content[i], m.Content[i+1]
+		if v.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("%s.%s: unexpected kind of node: expected a string", field, k.Value)
+		}
+		value := v.Value
+		if redact != nil {
+			value = redact(value)
+		}
+		e := *v
+		e.Value = base64.StdEncoding.EncodeToString([]byte(value))
+		e.Tag = "!!str"
+		e.Style = 0
+		encoded = append(encoded, k, &e)
+	}
+	return encoded, nil
+}
+
+// mappingIndex returns the index of the key in the mapping, or -1 when the mapping doesn't contain the key
+func mappingIndex(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// setMappingContent replaces the entries of the mapping at the index in the resource, removing the mapping when there's no entry left
+func setMappingContent(r *yaml.Node, i int, content []*yaml.Node) {
+	if len(content) == 0 {
+		r.Content = append(r.Content[:i], r.Content[i+2:]...)
+		return
+	}
+	r.Content[i+1].Content = content
+}
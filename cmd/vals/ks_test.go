@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v3"
+
+	"github.com/kroonprins/vals"
+)
+
+func encodeNode(t *testing.T, node *yaml.Node) string {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(node); err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	return buf.String()
+}
+
+func TestKsEncode(t *testing.T) {
+	in := `kind: Secret
+data:
+  kept: S0VQVA==
+  replaced: T0xE
+stringData:
+  replaced: new
+  added: "123"
+`
+	outExpected := `kind: Secret
+data:
+  kept: S0VQVA==
+  replaced: bmV3
+  added: MTIz
+`
+	var inNode yaml.Node
+	if err := yaml.Unmarshal([]byte(in), &inNode); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if err := KsEncode(&inNode); err != nil {
+		t.Fatalf("ksencode: %v", err)
+	}
+
+	if outActual := encodeNode(t, &inNode); outActual != outExpected {
+		t.Errorf("unexpected out: expected=%s, got=%s", outExpected, outActual)
+	}
+}
+
+func TestKsDecode_ListAndBinary(t *testing.T) {
+	binary := base64.StdEncoding.EncodeToString([]byte{0xff, 0xfe, 0x00})
+
+	in := fmt.Sprintf(`kind: List
+items:
+- kind: Secret
+  data:
+    foo: Rk9P
+    image: %s
+- kind: ConfigMap
+  data:
+    plain: text
+  binaryData:
+    bar: QkFS
+    image: %s
+`, binary, binary)
+	outExpected := fmt.Sprintf(`kind: List
+items:
+  - kind: Secret
+    data:
+      image: %s
+    stringData:
+      foo: FOO
+  - kind: ConfigMap
+    data:
+      plain: text
+      bar: BAR
+    binaryData:
+      image: %s
+`, binary, binary)
+
+	var inNode yaml.Node
+	if err := yaml.Unmarshal([]byte(in), &inNode); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	binaryKeys := ksBinaryKeys{}
+	outNode, err := ksDecode(inNode, 0, binaryKeys)
+	if err != nil {
+		t.Fatalf("ksdecode: %v", err)
+	}
+
+	if outActual := encodeNode(t, outNode); outActual != outExpected {
+		t.Errorf("unexpected out: expected=%s, got=%s", outExpected, outActual)
+	}
+
+	if diff := cmp.Diff(ksBinaryKeys{{Document: 0, Item: 1}: {"bar"}}, binaryKeys); diff != "" {
+		t.Errorf("unexpected binary keys: -(expected), +(got)\n%s", diff)
+	}
+
+	// KsDecode leaves ConfigMaps as is, as KsEncode can't move their "data" back to "binaryData"
+	if err := yaml.Unmarshal([]byte(in), &inNode); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	outNode, err = KsDecode(inNode)
+	if err != nil {
+		t.Fatalf("ksdecode: %v", err)
+	}
+
+	outExpected = fmt.Sprintf(`kind: List
+items:
+  - kind: Secret
+    data:
+      image: %s
+    stringData:
+      foo: FOO
+  - kind: ConfigMap
+    data:
+      plain: text
+    binaryData:
+      bar: QkFS
+      image: %s
+`, binary, binary)
+	if outActual := encodeNode(t, outNode); outActual != outExpected {
+		t.Errorf("unexpected out: expected=%s, got=%s", outExpected, outActual)
+	}
+}
+
+func TestKsRoundtrip(t *testing.T) {
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	in := fmt.Sprintf(`kind: Secret
+data:
+  password: %s
+---
+kind: ConfigMap
+binaryData:
+  config: %s
+`, b64("ref+echo://s3cr3t"), b64("token=ref+echo://t0ken"))
+
+	outExpected := fmt.Sprintf(`data:
+  password: %s
+kind: Secret
+---
+binaryData:
+  config: %s
+kind: ConfigMap
+`, b64("s3cr3t"), b64("token=t0ken"))
+
+	var nodes []yaml.Node
+	decoder := yaml.NewDecoder(strings.NewReader(in))
+	for {
+		var node yaml.Node
+		if err := decoder.Decode(&node); err != nil {
+			break
+		}
+		nodes = append(nodes, node)
+	}
+
+	binaryKeys := ksBinaryKeys{}
+	for i := range nodes {
+		decoded, err := ksDecode(nodes[i], i, binaryKeys)
+		if err != nil {
+			t.Fatalf("ksdecode: %v", err)
+		}
+		nodes[i] = *decoded
+	}
+
+	runtime, err := vals.New(vals.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// EvalNodes returns the root mappings of the documents, with the keys sorted
+	res, err := runtime.EvalNodes(context.Background(), nodes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := range res {
+		if err := ksEncode(&res[i], i, binaryKeys, nil); err != nil {
+			t.Fatalf("ksencode: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := vals.Output(&buf, "yaml", res); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != outExpected {
+		t.Errorf("unexpected out: expected=%s, got=%s", outExpected, buf.String())
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
-	"encoding/base64"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"time"
 
 	"github.com/kroonprins/vals"
 	"gopkg.in/yaml.v3"
@@ -25,7 +27,17 @@ Available Commands:
   eval		Evaluate a JSON/YAML document and replace any template expressions in it and prints the result
   exec		Populates the environment variables and executes the command
   env		Renders environment variables to be consumed by eval or a tool like direnv
+  render	Replace the refs in a plain-text file like app.ini, nginx.conf or .env
+  template	Render a Go text/template whose "ref" and "secretref" functions return the values of refs, for config files in any format
+  refs		List the refs in a JSON/YAML document without evaluating them
+  diff		Report the paths whose values would change compared to a previous render, without showing the values
+  scan		Report the plaintext values that look like secrets, and optionally move them to a backend and replace them with refs
+  put		Store a value at a ref, like "vals put ref+vault://secret/foo#/key value"
+  serve		Serve an HTTP API that evaluates refs for authenticated clients, sharing one cache among them
+  helm-post-render	Evaluate the refs in the manifests rendered by Helm, including Secret data, as a --post-renderer of "helm install" and "helm upgrade"
   ksdecode	Decode YAML document(s) by converting Secret resources' "data" to "stringData" for use with "vals eval"
+  ksencode	Encode YAML document(s) by converting Secret resources' "stringData" back to base64 "data", the reverse of "ksdecode"
+  cache		List or clear the entries of the on-disk cache used with --disk-cache
   version	Print vals version
 
 Use "vals [command] --help" for more information about a command
@@ -67,13 +79,31 @@ func writeOrFail(o *string, nodes []yaml.Node) {
 	}
 }
 
+// contextWithTimeout returns the context for evaluating refs, which is cancelled after the timeout if it's non-zero
+func contextWithTimeout(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
 func main() {
 	flag.Usage = flagUsage
 
 	CmdEval := "eval"
 	CmdExec := "exec"
 	CmdEnv := "env"
+	CmdRefs := "refs"
+	CmdPut := "put"
+	CmdScan := "scan"
+	CmdTemplate := "template"
+	CmdRender := "render"
+	CmdDiff := "diff"
+	CmdServe := "serve"
+	CmdHelmPostRender := "helm-post-render"
 	CmdKsDecode := "ksdecode"
+	CmdKsEncode := "ksencode"
+	CmdCache := "cache"
 	CmdVersion := "version"
 
 	if len(os.Args) == 1 {
@@ -87,37 +117,106 @@ func main() {
 		f := evalCmd.String("f", "-", "YAML/JSON file to be evaluated. When set to \"-\", vals reads from STDIN")
 		o := evalCmd.String("o", "yaml", "Output type which is either \"yaml\" or \"json\"")
 		e := evalCmd.Bool("exclude-secret", false, "Leave secretref+<uri> as-is and only replace ref+<uri>")
+		timeout := evalCmd.Duration("timeout", 0, "Give up evaluating refs after the duration, e.g. \"30s\". Zero means no timeout")
+		concurrency := evalCmd.Int("concurrency", 0, "Maximum number of refs resolved in parallel. Defaults to 8 when zero")
+		keepGoing := evalCmd.Bool("keep-going", false, "Report all the refs that failed to evaluate, instead of stopping at the first failure")
+		preserveFormat := evalCmd.Bool("preserve-format", false, "Replace only the values that contain refs, keeping comments, the order of keys, anchors and quoting styles of the input")
+		redact := evalCmd.Bool("redact", false, "Replace the values obtained for refs with placeholders like <redacted:1a2b3c4d> in the output and in error messages. Values under 6 characters are only redacted as whole values, and errors from backends are not redacted")
+		redactOnly := evalCmd.String("redact-only", "", "Redact only the values of the refs of the kind, like \"secretref\". Implies --redact")
+		ksRoundtrip := evalCmd.Bool("ks-roundtrip", false, "Decode the \"data\" of Secrets and the \"binaryData\" of ConfigMaps before evaluating, like ksdecode, and encode them back after evaluating, like ksencode")
+		cache := addCacheFlags(evalCmd)
 		evalCmd.Parse(os.Args[2:])
 
 		nodes := readNodesOrFail(f)
 
-		var res []yaml.Node
-		for _, node := range nodes {
-			var nodeValue map[string]interface{}
-			err := node.Decode(&nodeValue)
-			if err != nil {
-				fatal("%v", err)
+		ctx, cancel := contextWithTimeout(*timeout)
+		defer cancel()
+
+		var (
+			redactor *vals.Redactor
+			output   io.Writer = os.Stdout
+		)
+		if *redact || *redactOnly != "" {
+			if *redactOnly != "" {
+				redactor = vals.NewRedactor(*redactOnly)
+			} else {
+				redactor = vals.NewRedactor()
 			}
-			evalResult, err := vals.Eval(nodeValue, vals.Options{ExcludeSecret: *e})
-			if err != nil {
+			output = redactor.Writer(os.Stdout)
+		}
+
+		runtime, err := vals.New(vals.Options{
+			ExcludeSecret: *e,
+			Concurrency:   *concurrency,
+			KeepGoing:     *keepGoing,
+			DiskCache:     cache.diskCacheOrFail(),
+			Redactor:      redactor,
+			RedactErrors:  redactor != nil,
+		})
+		if err != nil {
+			fatal("%v", err)
+		}
+
+		var binaryKeys ksBinaryKeys
+		if *ksRoundtrip {
+			binaryKeys = ksBinaryKeys{}
+			for i := range nodes {
+				decoded, err := ksDecode(nodes[i], i, binaryKeys)
+				if err != nil {
+					fatal("document %d: %v", i, err)
+				}
+				nodes[i] = *decoded
+			}
+		}
+		ksEncodeOrFail := func(nodes []yaml.Node) {
+			if !*ksRoundtrip {
+				return
+			}
+			var redact func(string) string
+			if redactor != nil {
+				redact = redactor.Redact
+			}
+			for i := range nodes {
+				if err := ksEncode(&nodes[i], i, binaryKeys, redact); err != nil {
+					fatal("document %d: %v", i, err)
+				}
+			}
+		}
+
+		if *preserveFormat {
+			if err := runtime.EvalNodesInPlace(ctx, nodes); err != nil {
 				fatal("%v", err)
 			}
-			err = node.Encode(evalResult)
-			if err != nil {
+			ksEncodeOrFail(nodes)
+
+			if err := vals.OutputNodes(output, *o, nodes); err != nil {
 				fatal("%v", err)
 			}
-			res = append(res, node)
+			return
 		}
 
-		writeOrFail(o, res)
+		res, err := runtime.EvalNodes(ctx, nodes)
+		if err != nil {
+			fatal("%v", err)
+		}
+		ksEncodeOrFail(res)
+
+		if err := vals.Output(output, *o, res); err != nil {
+			fatal("%v", err)
+		}
 	case CmdExec:
 		execCmd := flag.NewFlagSet(CmdExec, flag.ExitOnError)
 		f := execCmd.String("f", "", "YAML/JSON file to be loaded to set envvars")
+		timeout := execCmd.Duration("timeout", 0, "Give up evaluating refs after the duration, e.g. \"30s\". Zero means no timeout")
+		cache := addCacheFlags(execCmd)
 		execCmd.Parse(os.Args[2:])
 
 		m := readOrFail(f)
 
-		err := vals.Exec(m, execCmd.Args())
+		ctx, cancel := contextWithTimeout(*timeout)
+		defer cancel()
+
+		err := vals.ExecContext(ctx, m, execCmd.Args(), vals.Options{DiskCache: cache.diskCacheOrFail()})
 		if err != nil {
 			fatal("%v", err)
 		}
@@ -125,11 +224,16 @@ func main() {
 		execEnv := flag.NewFlagSet(CmdEnv, flag.ExitOnError)
 		f := execEnv.String("f", "", "YAML/JSON file to be loaded to set envvars")
 		export := execEnv.Bool("export", false, "Prepend 'export' to each line")
+		timeout := execEnv.Duration("timeout", 0, "Give up evaluating refs after the duration, e.g. \"30s\". Zero means no timeout")
+		cache := addCacheFlags(execEnv)
 		execEnv.Parse(os.Args[2:])
 
 		m := readOrFail(f)
 
-		env, err := vals.Env(m)
+		ctx, cancel := contextWithTimeout(*timeout)
+		defer cancel()
+
+		env, err := vals.EnvContext(ctx, m, vals.Options{DiskCache: cache.diskCacheOrFail()})
 		if err != nil {
 			fatal("%v", err)
 		}
@@ -139,6 +243,36 @@ func main() {
 			}
 			fmt.Fprintln(os.Stdout, l)
 		}
+	case CmdRefs:
+		refsCmd := flag.NewFlagSet(CmdRefs, flag.ExitOnError)
+		f := refsCmd.String("f", "-", "YAML/JSON file to be scanned for refs. When set to \"-\", vals reads from STDIN")
+		o := refsCmd.String("o", "table", "Output type which is either \"table\" or \"json\"")
+		refsCmd.Parse(os.Args[2:])
+
+		nodes := readNodesOrFail(f)
+
+		refs, err := vals.ExtractRefs(nodes)
+		if err != nil {
+			fatal("%v", err)
+		}
+
+		if err := writeRefs(os.Stdout, *o, refs); err != nil {
+			fatal("%v", err)
+		}
+	case CmdDiff:
+		runDiff(os.Args[2:])
+	case CmdPut:
+		runPut(os.Args[2:])
+	case CmdScan:
+		runScan(os.Args[2:])
+	case CmdTemplate:
+		runTemplate(os.Args[2:])
+	case CmdRender:
+		runRender(os.Args[2:])
+	case CmdServe:
+		runServe(os.Args[2:])
+	case CmdHelmPostRender:
+		runHelmPostRender(os.Args[2:])
 	case CmdKsDecode:
 		evalCmd := flag.NewFlagSet(CmdKsDecode, flag.ExitOnError)
 		f := evalCmd.String("f", "", "YAML/JSON file to be decoded")
@@ -157,6 +291,23 @@ func main() {
 		}
 
 		writeOrFail(o, res)
+	case CmdKsEncode:
+		encodeCmd := flag.NewFlagSet(CmdKsEncode, flag.ExitOnError)
+		f := encodeCmd.String("f", "", "YAML/JSON file to be encoded")
+		o := encodeCmd.String("o", "yaml", "Output type which is either \"yaml\" or \"json\"")
+		encodeCmd.Parse(os.Args[2:])
+
+		nodes := readNodesOrFail(f)
+
+		for i := range nodes {
+			if err := KsEncode(&nodes[i]); err != nil {
+				fatal("%v", err)
+			}
+		}
+
+		writeOrFail(o, nodes)
+	case CmdCache:
+		runCache(os.Args[2:])
 	case CmdVersion:
 		if len(version) == 0 {
 			fmt.Println("Version: dev")
@@ -168,58 +319,3 @@ func main() {
 		flag.Usage()
 	}
 }
-
-func KsDecode(node yaml.Node) (*yaml.Node, error) {
-	if node.Kind != yaml.DocumentNode {
-		return nil, fmt.Errorf("unexpected kind of node: expected %d, got %d", yaml.DocumentNode, node.Kind)
-	}
-
-	var res yaml.Node
-	res = node
-
-	var kk yaml.Node
-	var vv yaml.Node
-	var ii int
-
-	isSecret := false
-	mappings := node.Content[0].Content
-	for i := 0; i < len(mappings); i += 2 {
-		j := i + 1
-		k := mappings[i]
-		v := mappings[j]
-
-		if k.Value == "kind" && v.Value == "Secret" {
-			isSecret = true
-		}
-
-		if k.Value == "data" {
-			ii = i
-			kk = *k
-			vv = *v
-		}
-	}
-
-	if isSecret && !kk.IsZero() {
-		kk.Value = "stringData"
-
-		v := vv
-		nestedMappings := v.Content
-		v.Content = make([]*yaml.Node, len(v.Content))
-		for i := 0; i < len(nestedMappings); i += 2 {
-			b64 := nestedMappings[i+1].Value
-			decoded, err := base64.StdEncoding.DecodeString(b64)
-			if err != nil {
-				return nil, err
-			}
-			nestedMappings[i+1].Value = string(decoded)
-
-			v.Content[i] = nestedMappings[i]
-			v.Content[i+1] = nestedMappings[i+1]
-		}
-
-		res.Content[0].Content[ii] = &kk
-		res.Content[0].Content[ii+1] = &v
-	}
-
-	return &res, nil
-}
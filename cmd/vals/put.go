@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kroonprins/vals"
+)
+
+func putUsage(cmd *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprint(os.Stderr, `Usage:
+  vals put [flags] REF [VALUE]
+
+Stores the value at the ref, like "ref+vault://secret/foo#/key".
+The value is read from STDIN when VALUE is omitted or "-".
+
+Flags:
+`)
+		cmd.PrintDefaults()
+	}
+}
+
+// runPut runs `vals put`
+func runPut(args []string) {
+	cmd := flag.NewFlagSet("put", flag.ExitOnError)
+	timeout := cmd.Duration("timeout", 0, "Give up storing the value after the duration, e.g. \"30s\". Zero means no timeout")
+	keepNewline := cmd.Bool("keep-newline", false, "Keep the trailing newline of the value read from STDIN, which is removed by default")
+	cmd.Usage = putUsage(cmd)
+	cmd.Parse(args)
+
+	if cmd.NArg() < 1 || cmd.NArg() > 2 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+
+	ref := cmd.Arg(0)
+	value := cmd.Arg(1)
+	if cmd.NArg() == 1 || value == "-" {
+		bs, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fatal("%v", err)
+		}
+		value = string(bs)
+		if !*keepNewline {
+			value = strings.TrimSuffix(strings.TrimSuffix(value, "\n"), "\r")
+		}
+	}
+
+	ctx, cancel := contextWithTimeout(*timeout)
+	defer cancel()
+
+	runtime, err := vals.New(vals.Options{})
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if err := runtime.Put(ctx, ref, value); err != nil {
+		fatal("%v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kroonprins/vals"
+)
+
+// writeRefs writes the refs extracted by `vals refs` in either the "table" or the "json" format
+func writeRefs(w io.Writer, format string, refs []vals.Ref) error {
+	switch format {
+	case "json":
+		if refs == nil {
+			refs = []vals.Ref{}
+		}
+		bs, err := json.MarshalIndent(refs, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(bs))
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "KIND\tSCHEME\tPATH\tFRAGMENT\tQUERY\tTRANSFORMS\tLOCATION")
+		for _, r := range refs {
+			// Unescaped for readability, as the query is only displayed
+			query, err := url.QueryUnescape(r.Query.Encode())
+			if err != nil {
+				query = r.Query.Encode()
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d:%d:%d %s\n", r.Kind, r.Scheme, r.Path, r.Fragment, query, strings.Join(r.Transforms, "|"), r.Document, r.Line, r.Column, r.YAMLPath)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unsupported output format %q: expected either \"table\" or \"json\"", format)
+	}
+	return nil
+}
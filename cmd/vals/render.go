@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+
+	"github.com/kroonprins/vals"
+)
+
+// runRender runs `vals render`, which replaces the refs in a plain-text file like app.ini, nginx.conf or .env
+func runRender(args []string) {
+	cmd := flag.NewFlagSet("render", flag.ExitOnError)
+	f := cmd.String("f", "-", "Plain-text file to be rendered. When set to \"-\", vals reads from STDIN")
+	o := cmd.String("o", "-", "File to write the rendered text to, with the permission 0600 unless it exists. When set to \"-\", vals writes to STDOUT")
+	e := cmd.Bool("exclude-secret", false, "Leave secretref+<uri> as-is and only replace ref+<uri>")
+	timeout := cmd.Duration("timeout", 0, "Give up evaluating refs after the duration, e.g. \"30s\". Zero means no timeout")
+	concurrency := cmd.Int("concurrency", 0, "Maximum number of refs resolved in parallel. Defaults to 8 when zero")
+	keepGoing := cmd.Bool("keep-going", false, "Report all the refs that failed to evaluate, instead of stopping at the first failure")
+	redact := cmd.Bool("redact", false, "Replace the values obtained for refs with placeholders like <redacted:1a2b3c4d> in the output and in error messages. Values under 6 characters are only redacted as whole values, and errors from backends are not redacted")
+	cache := addCacheFlags(cmd)
+	cmd.Parse(args)
+
+	text, err := vals.InputText(*f)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	ctx, cancel := contextWithTimeout(*timeout)
+	defer cancel()
+
+	var redactor *vals.Redactor
+	if *redact {
+		redactor = vals.NewRedactor()
+	}
+
+	runtime, err := vals.New(vals.Options{
+		ExcludeSecret: *e,
+		Concurrency:   *concurrency,
+		KeepGoing:     *keepGoing,
+		DiskCache:     cache.diskCacheOrFail(),
+		Redactor:      redactor,
+		RedactErrors:  redactor != nil,
+	})
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	res, err := runtime.EvalText(ctx, text)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if redactor != nil {
+		res = redactor.Redact(res)
+	}
+
+	if *o == "-" {
+		if _, err := io.WriteString(os.Stdout, res); err != nil {
+			fatal("%v", err)
+		}
+		return
+	}
+
+	if err := os.WriteFile(*o, []byte(res), 0600); err != nil {
+		fatal("%v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/kroonprins/vals"
+)
+
+// runScan runs `vals scan`, which reports the plaintext values that look like secrets,
+// and optionally stores them at refs and rewrites the file with the refs
+func runScan(args []string) {
+	cmd := flag.NewFlagSet("scan", flag.ExitOnError)
+	f := cmd.String("f", "-", "YAML/JSON file to be scanned for secrets. When set to \"-\", vals reads from STDIN")
+	o := cmd.String("o", "table", "Output type which is either \"table\" or \"json\"")
+	writeTo := cmd.String("write-to", "", "Store the secrets under the ref, like \"ref+vault://secret/app\", and rewrite the file with the refs to the stored values. When reading from STDIN, the rewritten file is written to STDOUT and the findings to STDERR")
+	exitCode := cmd.Bool("exit-code", false, "Exit with 1 when secrets are found, and with 0 otherwise. Ignored with --write-to")
+	timeout := cmd.Duration("timeout", 0, "Give up writing values after the duration, e.g. \"30s\". Zero means no timeout")
+	cmd.Parse(args)
+
+	nodes := readNodesOrFail(f)
+
+	findings := vals.ScanSecrets(nodes)
+
+	if *writeTo == "" {
+		if err := writeFindings(os.Stdout, *o, findings); err != nil {
+			fatal("%v", err)
+		}
+		if *exitCode && len(findings) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	ctx, cancel := contextWithTimeout(*timeout)
+	defer cancel()
+
+	runtime, err := vals.New(vals.Options{})
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if err := runtime.WriteSecretsTo(ctx, findings, *writeTo, len(nodes) > 1); err != nil {
+		fatal("%v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := vals.OutputNodes(&buf, "yaml", nodes); err != nil {
+		fatal("%v", err)
+	}
+
+	report := io.Writer(os.Stdout)
+	if *f == "-" {
+		report = os.Stderr
+		if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+			fatal("%v", err)
+		}
+	} else {
+		info, err := os.Stat(*f)
+		if err != nil {
+			fatal("%v", err)
+		}
+		if err := os.WriteFile(*f, buf.Bytes(), info.Mode().Perm()); err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	if err := writeFindings(report, *o, findings); err != nil {
+		fatal("%v", err)
+	}
+}
+
+// writeFindings writes the secrets found by `vals scan` in either the "table" or the "json" format.
+// The values themselves are never written.
+func writeFindings(w io.Writer, format string, findings []vals.Finding) error {
+	switch format {
+	case "json":
+		if findings == nil {
+			findings = []vals.Finding{}
+		}
+		bs, err := json.MarshalIndent(findings, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(bs))
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "LOCATION\tPATH\tREASON")
+		for _, f := range findings {
+			fmt.Fprintf(tw, "%d:%d:%d\t%s\t%s\n", f.Document, f.Line, f.Column, f.Path, f.Reason)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unsupported output format %q: expected either \"table\" or \"json\"", format)
+	}
+	return nil
+}
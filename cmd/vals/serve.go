@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kroonprins/vals"
+	"github.com/kroonprins/vals/pkg/server"
+)
+
+// listFlag is a flag that can be repeated, each with a comma-separated list of values
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*f = append(*f, s)
+		}
+	}
+	return nil
+}
+
+// runServe runs `vals serve`, which evaluates refs for clients over HTTP with one long-lived runtime,
+// so that the clients need neither the credentials of the backends nor their own cache
+func runServe(args []string) {
+	cmd := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := cmd.String("addr", ":8080", "Address to listen on")
+	tokenFile := cmd.String("token-file", "", "File of the bearer tokens accepted from clients, one per line. Lines starting with # are ignored")
+	tlsCert := cmd.String("tls-cert", "", "PEM-encoded certificate to serve HTTPS with")
+	tlsKey := cmd.String("tls-key", "", "PEM-encoded private key of --tls-cert")
+	clientCA := cmd.String("client-ca", "", "PEM-encoded CA certificates to authenticate clients with. Clients without a certificate signed by one of them need a bearer token. Requires --tls-cert")
+	var allowProviders, allowParams listFlag
+	cmd.Var(&allowProviders, "allow-provider", "Provider that clients may use, like \"vault\". Can be repeated or comma-separated. When unset, all providers are allowed but file, envsubst, exec, http, https and plugins, which read from the server itself")
+	cmd.Var(&allowParams, "allow-param", "Query parameter that refs may have, like \"region\". Can be repeated or comma-separated. Only \"timeout\", \"default\" and \"optional\" are allowed when unset")
+	timeout := cmd.Duration("timeout", 30*time.Second, "Give up evaluating the refs of a request after the duration. Zero means no timeout")
+	concurrency := cmd.Int("concurrency", 0, "Maximum number of refs resolved in parallel per request. Defaults to 8 when zero")
+	cacheSize := cmd.Int("cache-size", 512, "Number of values kept in the in-memory cache shared by all the requests")
+	accessLog := cmd.String("access-log", "-", "File to append the access log to. When set to \"-\", vals writes to STDERR")
+	cache := addCacheFlags(cmd)
+	cmd.Parse(args)
+
+	if *tokenFile == "" && *clientCA == "" {
+		fatal("vals serve: either --token-file or --client-ca is required, as clients must be authenticated")
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fatal("vals serve: --tls-cert and --tls-key must be set together")
+	}
+	if *clientCA != "" && *tlsCert == "" {
+		fatal("vals serve: --client-ca requires --tls-cert and --tls-key")
+	}
+
+	var tokens []string
+	if *tokenFile != "" {
+		var err error
+		tokens, err = readTokens(*tokenFile)
+		if err != nil {
+			fatal("vals serve: %v", err)
+		}
+	}
+
+	var log io.Writer = os.Stderr
+	if *accessLog != "-" {
+		f, err := os.OpenFile(*accessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			fatal("vals serve: %v", err)
+		}
+		defer f.Close()
+		log = f
+	}
+
+	runtime, err := vals.New(vals.Options{
+		CacheSize:    *cacheSize,
+		Concurrency:  *concurrency,
+		DiskCache:    cache.diskCacheOrFail(),
+		RedactErrors: true,
+	})
+	if err != nil {
+		fatal("vals serve: %v", err)
+	}
+
+	handler, err := server.New(server.Options{
+		Runtime:        runtime,
+		Tokens:         tokens,
+		AllowedSchemes: allowProviders,
+		AllowedParams:  allowParams,
+		Timeout:        *timeout,
+		AccessLog:      log,
+	})
+	if err != nil {
+		fatal("vals serve: %v", err)
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if *clientCA != "" {
+		pem, err := os.ReadFile(*clientCA)
+		if err != nil {
+			fatal("vals serve: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			fatal("vals serve: no certificate found in %s", *clientCA)
+		}
+		clientAuth := tls.RequireAndVerifyClientCert
+		if len(tokens) > 0 {
+			// Clients with tokens are authenticated by the server
+			clientAuth = tls.VerifyClientCertIfGiven
+		}
+		srv.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: clientAuth,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(os.Stderr, "vals serve: listening on %s\n", *addr)
+
+	if *tlsCert != "" {
+		err = srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatal("vals serve: %v", err)
+	}
+
+	// The providers, like the executables of plugins, are closed once the requests in flight are done
+	<-shutdown
+	if err := runtime.Close(); err != nil {
+		fatal("vals serve: %v", err)
+	}
+}
+
+// readTokens returns the tokens in the file, one per line, skipping empty lines and comments
+func readTokens(f string) ([]string, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no token found in %s", f)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/kroonprins/vals"
+)
+
+// runTemplate runs `vals template`, which renders a Go text/template whose `ref` and `secretref` functions return the values of refs
+func runTemplate(args []string) {
+	cmd := flag.NewFlagSet("template", flag.ExitOnError)
+	f := cmd.String("f", "-", "Go text/template file to be rendered. When set to \"-\", vals reads from STDIN")
+	values := cmd.String("values", "", "YAML/JSON file to be evaluated and passed to the template as its data, like {{ .db.host }}")
+	e := cmd.Bool("exclude-secret", false, "Leave secretref+<uri> as-is and only replace ref+<uri>")
+	timeout := cmd.Duration("timeout", 0, "Give up evaluating refs after the duration, e.g. \"30s\". Zero means no timeout")
+	cache := addCacheFlags(cmd)
+	cmd.Parse(args)
+
+	var (
+		text []byte
+		name string
+		err  error
+	)
+	if *f == "-" {
+		text, err = io.ReadAll(os.Stdin)
+		name = "stdin"
+	} else {
+		text, err = os.ReadFile(*f)
+		name = filepath.Base(*f)
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	ctx, cancel := contextWithTimeout(*timeout)
+	defer cancel()
+
+	runtime, err := vals.New(vals.Options{ExcludeSecret: *e, DiskCache: cache.diskCacheOrFail()})
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	var data interface{}
+	if *values != "" {
+		m := readOrFail(values)
+		data, err = runtime.EvalContext(ctx, m)
+		if err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	// Rendered into the buffer, so that nothing is written when the template fails midway
+	var buf bytes.Buffer
+	if err := runtime.RenderTemplate(ctx, &buf, name, string(text), data); err != nil {
+		fatal("%v", err)
+	}
+
+	if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+		fatal("%v", err)
+	}
+}
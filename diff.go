@@ -0,0 +1,236 @@
+package vals
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kroonprins/vals/pkg/transforms"
+)
+
+const (
+	// HashManifestKind is the kind of the documents that contain hash manifests,
+	// which tells them apart from rendered documents
+	HashManifestKind = "ValsHashManifest"
+
+	// EnvHashKey is the envvar that contains the key of the hashes of hash manifests
+	EnvHashKey = "VALS_DIFF_KEY"
+	// EnvHashKeyFile is the envvar that contains the path to the file whose content is the key of the hashes of hash manifests
+	EnvHashKeyFile = "VALS_DIFF_KEY_FILE"
+
+	// changeHashLength is the length of the prefixes of the hashes in changes, which is enough to tell values apart
+	changeHashLength = 12
+)
+
+// HashManifest is the keyed hashes of the values of YAML documents, keyed by the YAML paths of the values.
+// It tells which values changed between renders of documents without containing the values themselves.
+//
+// The values are hashed with HMAC-SHA256, so that the values can't be guessed from the hashes without the key,
+// which is never stored in the manifest.
+type HashManifest struct {
+	Kind string `json:"kind" yaml:"kind"`
+	// KeyID identifies the key of the hashes, so that manifests hashed with different keys aren't compared
+	KeyID string `json:"keyId" yaml:"keyId"`
+	// Documents is the hashes of the values of each document, keyed by the YAML paths like `db.password` and `hosts[0]`
+	Documents []map[string]string `json:"documents" yaml:"documents"`
+}
+
+// HashKeyFromEnv returns the key of the hashes of hash manifests from $VALS_DIFF_KEY_FILE or $VALS_DIFF_KEY
+func HashKeyFromEnv() ([]byte, error) {
+	if f := os.Getenv(EnvHashKeyFile); f != "" {
+		bs, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("reading hash key file %s: %w", f, err)
+		}
+		return bs, nil
+	}
+	if k := os.Getenv(EnvHashKey); k != "" {
+		return []byte(k), nil
+	}
+	return nil, fmt.Errorf("no hash key is configured: set either %s or %s", EnvHashKeyFile, EnvHashKey)
+}
+
+// NewHashManifest returns the hash manifest of the values in the YAML documents, hashed with the key
+func NewHashManifest(nodes []yaml.Node, key []byte) (*HashManifest, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("the key of the hashes is empty")
+	}
+
+	m := &HashManifest{
+		Kind:      HashManifestKind,
+		KeyID:     hashKeyID(key),
+		Documents: make([]map[string]string, 0, len(nodes)),
+	}
+
+	for i := range nodes {
+		var v interface{}
+		if err := nodes[i].Decode(&v); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+
+		hashes := map[string]string{}
+		if err := hashValues(hashes, "", v, key); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		m.Documents = append(m.Documents, hashes)
+	}
+
+	return m, nil
+}
+
+// hashKeyID returns the identifier of the key, which is the prefix of the HMAC of a constant message
+func hashKeyID(key []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte("vals hash manifest key"))
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// ParseHashManifest returns the hash manifest contained in the YAML documents,
+// or false if the documents aren't a hash manifest
+func ParseHashManifest(nodes []yaml.Node) (*HashManifest, bool, error) {
+	if len(nodes) != 1 {
+		return nil, false, nil
+	}
+
+	var kind struct {
+		Kind string `yaml:"kind"`
+	}
+	if err := nodes[0].Decode(&kind); err != nil || kind.Kind != HashManifestKind {
+		return nil, false, nil
+	}
+
+	var m HashManifest
+	if err := nodes[0].Decode(&m); err != nil {
+		return nil, false, fmt.Errorf("decoding hash manifest: %w", err)
+	}
+
+	return &m, true, nil
+}
+
+// hashValues adds the hashes of the scalars, the empty maps and the empty lists in v to hashes, keyed by their YAML paths
+func hashValues(hashes map[string]string, path string, v interface{}, key []byte) error {
+	switch typed := v.(type) {
+	case map[string]interface{}:
+		if len(typed) > 0 {
+			for k, v := range typed {
+				if err := hashValues(hashes, joinPath(path, k), v, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case map[interface{}]interface{}:
+		if len(typed) > 0 {
+			for k, v := range typed {
+				if err := hashValues(hashes, joinPath(path, fmt.Sprintf("%v", k)), v, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case []interface{}:
+		if len(typed) > 0 {
+			for i, v := range typed {
+				if err := hashValues(hashes, fmt.Sprintf("%s[%d]", path, i), v, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	// Values are hashed in JSON so that e.g. the string "80" and the number 80 have different hashes
+	bs, err := json.Marshal(transforms.ToJSONCompatible(v))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	h := hmac.New(sha256.New, key)
+	h.Write(bs)
+	hashes[path] = hex.EncodeToString(h.Sum(nil))
+
+	return nil
+}
+
+// ChangeType is how a value changed between renders
+type ChangeType string
+
+const (
+	ChangeAdded   ChangeType = "added"
+	ChangeRemoved ChangeType = "removed"
+	ChangeChanged ChangeType = "changed"
+)
+
+// Change is a value that changed between renders
+type Change struct {
+	Type     ChangeType `json:"type"`
+	Document int        `json:"document"`
+	Path     string     `json:"path"`
+	// OldHash and NewHash are the prefixes of the hashes of the values, which are empty for added and removed values respectively
+	OldHash string `json:"oldHash,omitempty"`
+	NewHash string `json:"newHash,omitempty"`
+}
+
+// DiffHashManifests returns the changes from the old manifest to the new one, sorted by the documents and the paths.
+// Both manifests must be hashed with the same key.
+func DiffHashManifests(old, new *HashManifest) ([]Change, error) {
+	if old.KeyID != new.KeyID {
+		return nil, fmt.Errorf("hash manifests are hashed with different keys")
+	}
+
+	var changes []Change
+
+	n := len(old.Documents)
+	if len(new.Documents) > n {
+		n = len(new.Documents)
+	}
+
+	for i := 0; i < n; i++ {
+		var o, m map[string]string
+		if i < len(old.Documents) {
+			o = old.Documents[i]
+		}
+		if i < len(new.Documents) {
+			m = new.Documents[i]
+		}
+
+		for path, oldHash := range o {
+			newHash, ok := m[path]
+			switch {
+			case !ok:
+				changes = append(changes, Change{Type: ChangeRemoved, Document: i, Path: path, OldHash: shortHash(oldHash)})
+			case newHash != oldHash:
+				changes = append(changes, Change{Type: ChangeChanged, Document: i, Path: path, OldHash: shortHash(oldHash), NewHash: shortHash(newHash)})
+			}
+		}
+		for path, newHash := range m {
+			if _, ok := o[path]; !ok {
+				changes = append(changes, Change{Type: ChangeAdded, Document: i, Path: path, NewHash: shortHash(newHash)})
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Document != changes[j].Document {
+			return changes[i].Document < changes[j].Document
+		}
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes, nil
+}
+
+// shortHash returns the prefix of the hash shown in changes
+func shortHash(h string) string {
+	if len(h) > changeHashLength {
+		return h[:changeHashLength]
+	}
+	return h
+}
@@ -0,0 +1,142 @@
+package vals
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestDiffHashManifests(t *testing.T) {
+	oldNodes, err := nodesFromReader(strings.NewReader(`db:
+  password: old
+  user: app
+port: "80"
+removed: x
+list: [a, b]
+---
+only: first
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newNodes, err := nodesFromReader(strings.NewReader(`db:
+  password: new
+  user: app
+port: 80
+added: {}
+list: [a]
+---
+only: first
+---
+third: doc
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := NewHashManifest(oldNodes, []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := NewHashManifest(newNodes, []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := DiffHashManifests(old, current)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Change{
+		{Type: ChangeAdded, Document: 0, Path: "added"},
+		{Type: ChangeChanged, Document: 0, Path: "db.password"},
+		{Type: ChangeRemoved, Document: 0, Path: "list[1]"},
+		{Type: ChangeChanged, Document: 0, Path: "port"},
+		{Type: ChangeRemoved, Document: 0, Path: "removed"},
+		{Type: ChangeAdded, Document: 2, Path: "third"},
+	}
+	if diff := cmp.Diff(expected, changes, cmpopts.IgnoreFields(Change{}, "OldHash", "NewHash")); diff != "" {
+		t.Errorf("unexpected changes: -(expected), +(got)\n%s", diff)
+	}
+
+	// Changes show only the prefixes of the hashes
+	for _, c := range changes {
+		for _, h := range []string{c.OldHash, c.NewHash} {
+			if len(h) > changeHashLength {
+				t.Errorf("unexpected length of the hash %q of %s", h, c.Path)
+			}
+		}
+	}
+
+	// Neither the values nor the key appear in the manifests
+	bs, err := json.Marshal(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"new", "app", "first", "key"} {
+		if strings.Contains(string(bs), `"`+v+`"`) {
+			t.Errorf("the manifest contains %q: %s", v, bs)
+		}
+	}
+
+	// A manifest written earlier can be compared against
+	manifestNodes, err := nodesFromReader(strings.NewReader(string(bs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, ok, err := ParseHashManifest(manifestNodes)
+	if err != nil || !ok {
+		t.Fatalf("unexpected result of parsing the manifest: ok=%v, err=%v", ok, err)
+	}
+	if changes, err := DiffHashManifests(parsed, current); err != nil || len(changes) != 0 {
+		t.Errorf("unexpected changes against the parsed manifest: %v, %v", changes, err)
+	}
+
+	if _, ok, _ := ParseHashManifest(oldNodes); ok {
+		t.Errorf("rendered documents are parsed as a hash manifest")
+	}
+
+	other, err := NewHashManifest(newNodes, []byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Documents[0]["db.password"] == current.Documents[0]["db.password"] {
+		t.Errorf("the hashes with different keys are the same")
+	}
+	if _, err := DiffHashManifests(other, current); err == nil {
+		t.Errorf("expected error for manifests with different keys")
+	}
+}
+
+func TestNewHashManifest_Paths(t *testing.T) {
+	nodes, err := nodesFromReader(strings.NewReader(`list: [a]
+"list[0]": b
+a:
+  b: c
+"a.b": d
+"[0]": e
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewHashManifest(nodes, []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for path := range m.Documents[0] {
+		paths = append(paths, path)
+	}
+
+	expected := []string{`["[0]"]`, `["a.b"]`, `["list[0]"]`, "a.b", "list[0]"}
+	if diff := cmp.Diff(expected, paths, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Errorf("unexpected paths: -(expected), +(got)\n%s", diff)
+	}
+}
@@ -0,0 +1,83 @@
+package vals
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RefError is the failure to evaluate a ref, located in the evaluated documents
+type RefError struct {
+	// Document is the index of the document that contains the ref, in the order of appearance in the input
+	Document int
+	// Path is the YAML path to the value or the key that contains the ref, like `foo.bar[0]`.
+	// It is empty for refs in plain text.
+	Path string
+	// Line and Column are the 1-based position in the input of the value or the key that contains the ref.
+	// Both are zero when the ref isn't evaluated from a YAML node, like with Eval.
+	Line   int
+	Column int
+	// Ref is the ref without the `ref+` prefix, like `vault://foo/bar#/baz`
+	Ref string
+	Err error
+}
+
+func (e *RefError) Error() string {
+	if e.Line == 0 {
+		if e.Path == "" {
+			// Refs that aren't located in any document have nothing to show before the ref
+			return fmt.Sprintf("expand %s: %v", e.Ref, e.Err)
+		}
+		return fmt.Sprintf("%s: expand %s: %v", e.Path, e.Ref, e.Err)
+	}
+	if e.Path == "" {
+		// Refs in plain text, evaluated with EvalText, have no YAML paths
+		return fmt.Sprintf("line %d, column %d: expand %s: %v", e.Line, e.Column, e.Ref, e.Err)
+	}
+	return fmt.Sprintf("%s (document %d, line %d, column %d): expand %s: %v", e.Path, e.Document, e.Line, e.Column, e.Ref, e.Err)
+}
+
+func (e *RefError) Unwrap() error {
+	return e.Err
+}
+
+// EvalError is returned when one or more refs failed to evaluate.
+// It contains all the failures when Options.KeepGoing is set, or only the first one otherwise.
+type EvalError struct {
+	Errors []*RefError
+}
+
+func (e *EvalError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d refs failed to evaluate:", len(e.Errors))
+	for _, err := range e.Errors {
+		sb.WriteString("\n  ")
+		sb.WriteString(err.Error())
+	}
+	return sb.String()
+}
+
+// Is reports whether any of the failures matches the target, so that errors.Is(err, context.DeadlineExceeded)
+// works for evaluations that timed out
+func (e *EvalError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first failure that matches the target
+func (e *EvalError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
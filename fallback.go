@@ -0,0 +1,102 @@
+package vals
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kroonprins/vals/pkg/expansion"
+)
+
+// fallbackSource matches the start of a ref URI or a double-quoted literal following `||` in a ref
+var fallbackSource = regexp.MustCompile(`^([^\+:"|]*://|")`)
+
+// splitFallbacks splits the ref like `vault://a||awsssm://b||"literal"` into the sources to be tried in order.
+// `||` is a separator only when it is followed by a ref URI or a double-quoted literal,
+// so that `||` in the ref itself, like in JMESPath expressions, is left as is.
+// `||` in nested refs like `vault://{{ref+awsssm://a||awsssm://b}}` separates the fallbacks of the nested refs instead.
+func splitFallbacks(ref string) []string {
+	parts := strings.Split(ref, "||")
+
+	sources := []string{parts[0]}
+	for _, p := range parts[1:] {
+		last := sources[len(sources)-1]
+		if fallbackSource.MatchString(p) && strings.Count(last, "{{") <= strings.Count(last, "}}") {
+			sources = append(sources, p)
+		} else {
+			sources[len(sources)-1] += "||" + p
+		}
+	}
+
+	return sources
+}
+
+// parseLiteral returns the value of the double-quoted literal, like `"info"`, used as the last resort of a fallback chain
+func parseLiteral(source string) (string, bool) {
+	if len(source) < 2 || !strings.HasPrefix(source, `"`) || !strings.HasSuffix(source, `"`) {
+		return "", false
+	}
+	if s, err := strconv.Unquote(source); err == nil {
+		return s, true
+	}
+	return source[1 : len(source)-1], true
+}
+
+// defaultValue returns the value of the `default` query parameter of the ref, if any
+func defaultValue(ref string) (string, bool) {
+	uri, err := url.Parse(ref)
+	if err != nil {
+		return "", false
+	}
+	vs, ok := uri.Query()[ParamDefault]
+	if !ok || len(vs) == 0 {
+		return "", false
+	}
+	return vs[0], true
+}
+
+// optionalValue returns the value for the ref that failed with api.ErrNotFound, according to its `optional` query parameter
+func optionalValue(ref string) (interface{}, bool, error) {
+	uri, err := url.Parse(ref)
+	if err != nil {
+		return nil, false, nil
+	}
+	if isProviderParam(uri.Scheme, ParamOptional) {
+		return nil, false, nil
+	}
+	switch o := uri.Query().Get(ParamOptional); o {
+	case "":
+		return nil, false, nil
+	case "true":
+		return expansion.Omit, true, nil
+	case "null":
+		return nil, true, nil
+	default:
+		return nil, false, fmt.Errorf("invalid %s %q: expected either \"true\" or \"null\"", ParamOptional, o)
+	}
+}
+
+// fallbackError is returned when all the sources of a ref with fallbacks failed
+type fallbackError struct {
+	sources []string
+	errs    []error
+}
+
+func (e *fallbackError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("all the fallbacks failed")
+	for i, s := range e.sources {
+		sb.WriteString("; ")
+		sb.WriteString(s)
+		sb.WriteString(": ")
+		sb.WriteString(e.errs[i].Error())
+	}
+	return sb.String()
+}
+
+// Unwrap returns the error of the last source
+func (e *fallbackError) Unwrap() error {
+	return e.errs[len(e.errs)-1]
+}
@@ -0,0 +1,99 @@
+package vals
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitFallbacks(t *testing.T) {
+	testcases := []struct {
+		ref      string
+		expected []string
+	}{
+		{ref: "vault://a", expected: []string{"vault://a"}},
+		{ref: `vault://a||awsssm://b||"literal"`, expected: []string{"vault://a", "awsssm://b", `"literal"`}},
+		{ref: `echo://a|upper||"with || inside"`, expected: []string{"echo://a|upper", `"with || inside"`}},
+		{ref: "awssecrets://x#jmespath=a || b", expected: []string{"awssecrets://x#jmespath=a || b"}},
+		{ref: "vault://{{ref+awsssm://a||awsssm://b}}/x||echo://c", expected: []string{"vault://{{ref+awsssm://a||awsssm://b}}/x", "echo://c"}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.ref, func(t *testing.T) {
+			if diff := cmp.Diff(tc.expected, splitFallbacks(tc.ref)); diff != "" {
+				t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEval_Fallbacks(t *testing.T) {
+	var log bytes.Buffer
+
+	actual, err := Eval(map[string]interface{}{
+		"primary":   "ref+echo://primary||testfailing://x",
+		"fallback":  "ref+testfailing://x||echo://fallback",
+		"literal":   `ref+testfailing://x||testfailing://y||"some literal"`,
+		"default":   "ref+testfailing://x?default=info",
+		"chained":   "ref+testfailing://x||testfailing://y?default=debug",
+		"transform": "ref+testfailing://x||echo://Zm9v|b64dec",
+	}, Options{LogOutput: &log, Concurrency: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"primary":   "primary",
+		"fallback":  "fallback",
+		"literal":   "some literal",
+		"default":   "info",
+		"chained":   "debug",
+		"transform": "foo",
+	}
+
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+	}
+
+	for _, msg := range []string{
+		"using the value of the fallback echo://fallback for testfailing://x",
+		`using the literal "some literal" for testfailing://x`,
+		"using the default value for testfailing://x?default=info",
+	} {
+		if !strings.Contains(log.String(), msg) {
+			t.Errorf("expected %q to be logged, got:\n%s", msg, log.String())
+		}
+	}
+	if strings.Contains(log.String(), "echo://primary") {
+		t.Errorf("unexpected log for the primary ref:\n%s", log.String())
+	}
+
+	log.Reset()
+	redactor := NewRedactor()
+	_, err = Eval(map[string]interface{}{
+		"literal": `ref+testfailing://x||"some literal"`,
+		"default": "ref+testfailing://x?default=information",
+	}, Options{LogOutput: &log, Redactor: redactor, Concurrency: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, v := range []string{"some literal", "information"} {
+		if strings.Contains(log.String(), v) {
+			t.Errorf("expected %q to be redacted, got:\n%s", v, log.String())
+		}
+		if !strings.Contains(log.String(), redactedPlaceholder(v)) {
+			t.Errorf("expected the placeholder of %q to be logged, got:\n%s", v, log.String())
+		}
+	}
+
+	_, err = Eval(map[string]interface{}{"v": "ref+testfailing://x||testfailing://y"}, Options{LogOutput: &log})
+	if !errors.Is(err, errTestFailing) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "all the fallbacks failed; testfailing://x: not found; testfailing://y: not found") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package vals
+
+import (
+	"sync"
+)
+
+// inflight collapses concurrent calls for the same key into a single call,
+// so that e.g. a document referenced by many refs is fetched from the provider only once.
+type inflight struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+}
+
+type inflightCall struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+	// panicked is the value fn panicked with, if it did
+	panicked interface{}
+}
+
+// Do calls fn and returns its results, unless a call for the same key is already in flight.
+// In that case it waits for the in-flight call to complete and returns its results instead.
+// When fn panics, the panic is passed on to the callers waiting for the call as well.
+func (g *inflight) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*inflightCall{}
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		if c.panicked != nil {
+			panic(c.panicked)
+		}
+		return c.val, c.err
+	}
+	c := &inflightCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		if v := recover(); v != nil {
+			c.panicked = v
+		}
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+
+		if c.panicked != nil {
+			panic(c.panicked)
+		}
+	}()
+
+	c.val, c.err = fn()
+	return c.val, c.err
+}
@@ -0,0 +1,62 @@
+package vals
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInflight_Panic(t *testing.T) {
+	var g inflight
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+
+	var wg sync.WaitGroup
+	recovered := make([]interface{}, 2)
+	for i := range recovered {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { recovered[i] = recover() }()
+
+			if i == 1 {
+				<-started
+			}
+			g.Do("key", func() (interface{}, error) {
+				once.Do(func() { close(started) })
+				<-release
+				panic("boom")
+			})
+		}()
+	}
+
+	// The second caller waits for the call of the first one
+	<-started
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the callers waiting for the call that panicked are blocked")
+	}
+
+	for i, v := range recovered {
+		if v != "boom" {
+			t.Errorf("unexpected panic of caller %d: expected %q, got %v", i, "boom", v)
+		}
+	}
+
+	// The key is released for the next calls
+	if v, err := g.Do("key", func() (interface{}, error) { return "ok", nil }); err != nil || v != "ok" {
+		t.Errorf("unexpected result after the panic: %v, %v", v, err)
+	}
+}
@@ -27,6 +27,27 @@ func Inputs(f string) ([]yaml.Node, error) {
 	return nodesFromReader(reader)
 }
 
+// InputText reads the file as plain text, unlike Inputs which decodes YAML documents.
+// It reads from stdin when f is "-".
+func InputText(f string) (string, error) {
+	var (
+		bs  []byte
+		err error
+	)
+	switch f {
+	case "-":
+		bs, err = io.ReadAll(os.Stdin)
+	case "":
+		return "", fmt.Errorf("nothing to eval: No file specified")
+	default:
+		bs, err = os.ReadFile(f)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
 func nodesFromReader(reader io.Reader) ([]yaml.Node, error) {
 	nodes := []yaml.Node{}
 	buf := bufio.NewReader(reader)
@@ -44,12 +65,18 @@ func nodesFromReader(reader io.Reader) ([]yaml.Node, error) {
 	return nodes, nil
 }
 
+// Output writes the YAML documents in the format, which is either "yaml" or "json".
+// When output is the writer returned by Redactor.Writer, the recorded values are redacted from the documents.
 func Output(output io.Writer, format string, nodes []yaml.Node) error {
+	redactor, output := redactorOf(output)
 	for i, node := range nodes {
 		var v interface{}
 		if err := node.Decode(&v); err != nil {
 			return err
 		}
+		if redactor != nil {
+			v = redactor.RedactValue(v)
+		}
 		if format == "json" {
 			bs, err := json.Marshal(v)
 			if err != nil {
@@ -70,3 +97,41 @@ func Output(output io.Writer, format string, nodes []yaml.Node) error {
 	}
 	return nil
 }
+
+// OutputNodes is Output that encodes the YAML nodes as they are, instead of re-encoding their decoded values,
+// so that comments, the order of keys, anchors and the styles of scalars are kept.
+// The "json" format is written as Output does.
+func OutputNodes(output io.Writer, format string, nodes []yaml.Node) error {
+	if format == "json" {
+		return Output(output, format, nodes)
+	}
+	redactor, output := redactorOf(output)
+	for i := range nodes {
+		encoder := yaml.NewEncoder(output)
+		encoder.SetIndent(2)
+
+		node := &nodes[i]
+		if redactor != nil {
+			node = redactor.redactNode(node)
+		}
+		if err := encoder.Encode(node); err != nil {
+			return err
+		}
+		if err := encoder.Close(); err != nil {
+			return err
+		}
+		if i != len(nodes)-1 {
+			fmt.Fprintln(output, "---")
+		}
+	}
+	return nil
+}
+
+// redactorOf returns the Redactor and the underlying writer if the writer is returned by Redactor.Writer,
+// or nil and the writer as is otherwise
+func redactorOf(w io.Writer) (*Redactor, io.Writer) {
+	if rw, ok := w.(*redactingWriter); ok {
+		return rw.r, rw.w
+	}
+	return nil, w
+}
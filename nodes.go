@@ -0,0 +1,218 @@
+package vals
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kroonprins/vals/pkg/expansion"
+)
+
+// EvalNodesInPlace evaluates the YAML documents like EvalNodes does, but by rewriting only the scalars that contain refs
+// in the given nodes. Comments, the order of keys, anchors and the styles of the other scalars are kept intact,
+// so that encoding the nodes, e.g. with OutputNodes, changes nothing other than the evaluated values.
+//
+// Refs that evaluate to maps replace the scalars with mappings, and keys that evaluate to maps are replaced
+// with the entries of the maps, as with EvalContext.
+// The entries and the items that contain missing optional refs are removed.
+func (r *Runtime) EvalNodesInPlace(ctx context.Context, nodes []yaml.Node) error {
+	expand := r.expander()
+
+	var locations []refLocation
+	for i := range nodes {
+		locations = append(locations, locateRefsInNode(&expand, i, &nodes[i])...)
+	}
+
+	lookup, err := r.resolve(ctx, locations)
+	if err != nil {
+		return err
+	}
+
+	expand.Lookup = lookup
+
+	for i := range nodes {
+		err := expandNode(&expand, &nodes[i])
+		if errors.Is(err, errOmitted) {
+			err = setNodeValue(&expand, &nodes[i], nil)
+		}
+		if err != nil {
+			if len(nodes) > 1 {
+				return fmt.Errorf("document %d: %w", i, err)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// errOmitted is returned by expandNode for the scalar that contains a missing optional ref,
+// so that the entry or the item containing the scalar is removed
+var errOmitted = errors.New("omitted")
+
+func expandNode(expand *expansion.ExpandRegexMatch, n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			err := expandNode(expand, c)
+			if errors.Is(err, errOmitted) {
+				// There's nothing to remove the document from
+				err = setNodeValue(expand, c, nil)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		content := n.Content[:0]
+		for _, c := range n.Content {
+			err := expandNode(expand, c)
+			if errors.Is(err, errOmitted) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			content = append(content, c)
+		}
+		n.Content = content
+	case yaml.MappingNode:
+		return expandMapping(expand, n)
+	case yaml.ScalarNode:
+		if len(expand.Matches(n.Value)) == 0 {
+			return nil
+		}
+		v, err := expand.InString(n.Value)
+		if err != nil {
+			return err
+		}
+		if v == expansion.Omit {
+			return errOmitted
+		}
+		return setNodeValue(expand, n, v)
+	}
+	// Aliases are left as they are, as they refer to the expanded anchors
+
+	return nil
+}
+
+// expandMapping expands the keys and the values of the mapping.
+// A key that evaluates to a map, or to a string that is parsed as a YAML map, is replaced with the entries of the map,
+// which override the other entries with the same keys.
+func expandMapping(expand *expansion.ExpandRegexMatch, n *yaml.Node) error {
+	var (
+		content []*yaml.Node
+		merged  []*yaml.Node
+	)
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+
+		if k.Kind == yaml.ScalarNode && len(expand.Matches(k.Value)) > 0 {
+			expanded, err := expand.InString(k.Value)
+			if err != nil {
+				return err
+			}
+
+			if expanded == expansion.Omit {
+				continue
+			}
+
+			var m map[string]interface{}
+			switch typed := expanded.(type) {
+			case string:
+				if err := yaml.Unmarshal([]byte(typed), &m); err != nil || m == nil {
+					k.Value = typed
+					k.Tag = "!!str"
+				}
+			case map[string]interface{}:
+				m = typed
+			default:
+				// Keys are strings even if they evaluate to other scalars like numbers
+				k.Value = expansion.FormatScalar(typed)
+				k.Tag = "!!str"
+			}
+
+			if m != nil {
+				var mapping yaml.Node
+				if err := setNodeValue(expand, &mapping, m); err != nil {
+					return err
+				}
+				merged = append(merged, mapping.Content...)
+				continue
+			}
+		}
+
+		err := expandNode(expand, v)
+		if errors.Is(err, errOmitted) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		content = append(content, k, v)
+	}
+
+	for i := 0; i+1 < len(merged); i += 2 {
+		k, v := merged[i], merged[i+1]
+
+		var replaced bool
+		for j := 0; j+1 < len(content); j += 2 {
+			if content[j].Kind == yaml.ScalarNode && content[j].Value == k.Value {
+				content[j+1] = v
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			content = append(content, k, v)
+		}
+	}
+
+	n.Content = content
+
+	return nil
+}
+
+// setNodeValue replaces the value of the node with the evaluated value, keeping the comments of the node
+func setNodeValue(expand *expansion.ExpandRegexMatch, n *yaml.Node, v interface{}) error {
+	switch typed := v.(type) {
+	case string:
+		n.Kind = yaml.ScalarNode
+		n.Tag = "!!str"
+		n.Value = typed
+		return nil
+	case map[string]interface{}:
+		head, line, foot := n.HeadComment, n.LineComment, n.FootComment
+
+		var encoded yaml.Node
+		// The keys are sorted by the encoder
+		if err := encoded.Encode(typed); err != nil {
+			return err
+		}
+
+		*n = encoded
+		n.HeadComment, n.LineComment, n.FootComment = head, line, foot
+
+		// The values of the map may contain refs, too
+		return expandNode(expand, n)
+	default:
+		// Other scalars like numbers, booleans and null keep their types
+		var encoded yaml.Node
+		if err := encoded.Encode(typed); err != nil {
+			return err
+		}
+		if encoded.Kind != yaml.ScalarNode {
+			return fmt.Errorf("unexpected type of value: %T", v)
+		}
+
+		n.Kind = yaml.ScalarNode
+		n.Tag = encoded.Tag
+		n.Value = encoded.Value
+		n.Style = 0
+		return nil
+	}
+}
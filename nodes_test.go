@@ -0,0 +1,158 @@
+package vals
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/kroonprins/vals/pkg/api"
+)
+
+// mapProvider returns the same map for any key
+type mapProvider struct{}
+
+func (p *mapProvider) GetString(key string) (string, error) {
+	return key, nil
+}
+
+func (p *mapProvider) GetStringMap(key string) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"b":      "2",
+		"a":      "1",
+		"nested": "ref+echo://nested",
+	}, nil
+}
+
+func init() {
+	RegisterProvider("testmap", func(cfg api.StaticConfig) (api.Provider, error) {
+		return &mapProvider{}, nil
+	})
+}
+
+func TestEvalNodesInPlace(t *testing.T) {
+	testcases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "comments, order and styles",
+			input: `# head
+zeta: ref+echo://z # line
+alpha:
+  quoted: "ref+echo://q"
+  single: 'plain'
+  anchor: &a ref+echo://anchored
+  alias: *a
+  bool: ref+echo://true
+  list:
+    - ref+echo://item
+    - 42
+---
+# second
+b: x-ref+echo://y+
+`,
+			expected: `# head
+zeta: z # line
+alpha:
+  quoted: "q"
+  single: 'plain'
+  anchor: &a anchored
+  alias: *a
+  bool: "true"
+  list:
+    - item
+    - 42
+---
+# second
+b: x-y
+`,
+		},
+		{
+			name: "maps",
+			input: `value: ref+testmap://doc#/*
+ref+echo://renamed: kept
+ref+testmap://doc#/*: dropped
+a: overridden
+`,
+			expected: `value:
+  a: "1"
+  b: "2"
+  nested: nested
+renamed: kept
+a: "1"
+b: "2"
+nested: nested
+`,
+		},
+		{
+			name: "optional",
+			input: `found: ref?+testmap://doc#/a
+missing: ref?+testmap://doc#/missing
+null: ref+testmap://doc?optional=null#/missing
+ref?+testmap://doc#/missing: key
+list:
+  - ref?+testmap://doc#/missing
+  - ref?+testmap://doc#/b
+---
+ref?+testmap://doc#/missing
+`,
+			expected: `found: "1"
+null: null
+list:
+  - "2"
+---
+null
+`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			nodes, err := nodesFromReader(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			runtime, err := New(Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := runtime.EvalNodesInPlace(context.Background(), nodes); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if err := OutputNodes(&buf, "yaml", nodes); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tc.expected, buf.String()); diff != "" {
+				t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEvalNodesInPlace_Errors(t *testing.T) {
+	nodes, err := nodesFromReader(strings.NewReader("a: ok\nb: ref+testfailing://x\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runtime.EvalNodesInPlace(context.Background(), nodes)
+
+	expected := "b (document 0, line 2, column 4): expand testfailing://x: not found"
+	if err == nil || err.Error() != expected {
+		t.Errorf("unexpected error: expected %q, got %v", expected, err)
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+)
+
+// ContextStringProvider is a variant of LazyLoadedStringProvider that stops getting the value once the context is done
+type ContextStringProvider interface {
+	GetStringContext(context.Context, string) (string, error)
+}
+
+// ContextStringMapProvider is a variant of LazyLoadedStringMapProvider that stops getting the value once the context is done
+type ContextStringMapProvider interface {
+	GetStringMapContext(context.Context, string) (map[string]interface{}, error)
+}
+
+// ContextProvider is a Provider that supports cancellation and deadlines of lookups.
+// All the built-in providers implement it.
+type ContextProvider interface {
+	Provider
+	ContextStringProvider
+	ContextStringMapProvider
+}
+
+// ContextWritableProvider is a variant of WritableProvider that stops storing the value once the context is done.
+// All the built-in writable providers implement it.
+type ContextWritableProvider interface {
+	SetStringContext(context.Context, string, string) error
+	SetStringMapContext(context.Context, string, map[string]interface{}) error
+}
+
+// GetString gets the value for the key from the provider, honoring the cancellation and the deadline of ctx.
+//
+// Providers that don't implement ContextStringProvider are called in a separate goroutine
+// so that the caller is unblocked with ctx.Err() once ctx is done, even if the provider call hangs.
+func GetString(ctx context.Context, p LazyLoadedStringProvider, key string) (string, error) {
+	if cp, ok := p.(ContextStringProvider); ok {
+		return cp.GetStringContext(ctx, key)
+	}
+
+	type result struct {
+		v   string
+		err error
+	}
+
+	res := make(chan result, 1)
+	go func() {
+		v, err := p.GetString(key)
+		res <- result{v: v, err: err}
+	}()
+
+	select {
+	case r := <-res:
+		return r.v, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// GetStringMap gets the map for the key from the provider, honoring the cancellation and the deadline of ctx.
+// See GetString for how providers without the support for contexts are handled.
+func GetStringMap(ctx context.Context, p LazyLoadedStringMapProvider, key string) (map[string]interface{}, error) {
+	if cp, ok := p.(ContextStringMapProvider); ok {
+		return cp.GetStringMapContext(ctx, key)
+	}
+
+	type result struct {
+		v   map[string]interface{}
+		err error
+	}
+
+	res := make(chan result, 1)
+	go func() {
+		v, err := p.GetStringMap(key)
+		res <- result{v: v, err: err}
+	}()
+
+	select {
+	case r := <-res:
+		return r.v, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// SetString stores the value for the key with the provider, honoring the cancellation and the deadline of ctx.
+// See GetString for how providers without the support for contexts are handled,
+// whose writes may still complete after ctx is done.
+func SetString(ctx context.Context, p WritableProvider, key string, value string) error {
+	if cp, ok := p.(ContextWritableProvider); ok {
+		return cp.SetStringContext(ctx, key, value)
+	}
+
+	res := make(chan error, 1)
+	go func() {
+		res <- p.SetString(key, value)
+	}()
+
+	select {
+	case err := <-res:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetStringMap stores the map for the key with the provider, honoring the cancellation and the deadline of ctx.
+// See SetString for how providers without the support for contexts are handled.
+func SetStringMap(ctx context.Context, p WritableProvider, key string, m map[string]interface{}) error {
+	if cp, ok := p.(ContextWritableProvider); ok {
+		return cp.SetStringMapContext(ctx, key, m)
+	}
+
+	res := make(chan error, 1)
+	go func() {
+		res <- p.SetStringMap(key, m)
+	}()
+
+	select {
+	case err := <-res:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
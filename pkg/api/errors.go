@@ -0,0 +1,30 @@
+package api
+
+import (
+	"errors"
+)
+
+// ErrNotFound is matched with errors.Is by the errors that providers return when the requested secret or key doesn't exist,
+// so that they can be distinguished from other failures like network or permission errors
+var ErrNotFound = errors.New("not found")
+
+// NotFound marks the error as the failure to find the requested secret or key, without changing its message
+func NotFound(err error) error {
+	return &notFoundError{err: err}
+}
+
+type notFoundError struct {
+	err error
+}
+
+func (e *notFoundError) Error() string {
+	return e.err.Error()
+}
+
+func (e *notFoundError) Unwrap() error {
+	return e.err
+}
+
+func (e *notFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
@@ -71,6 +71,23 @@ type Provider interface {
 	LazyLoadedStringMapProvider
 }
 
+// WritableProvider is implemented by the providers that can also store values, which is used by `vals put`
+type WritableProvider interface {
+	// SetString stores the value for the key, so that GetString returns the value for the key
+	SetString(key string, value string) error
+	// SetStringMap stores the map for the key, replacing the whole map that GetStringMap returns for the key
+	SetStringMap(key string, m map[string]interface{}) error
+}
+
+// PathPerKeyProvider is implemented by the WritableProviders whose GetStringMap returns the values stored separately
+// under the path of the key, like the parameters under a path of `awsssm`, rather than a single document.
+// `vals put` stores only the value at the path of the key joined with the fragment of the ref for them,
+// instead of storing the whole map back.
+type PathPerKeyProvider interface {
+	// IsPathPerKey reports whether GetStringMap for the key returns the values stored under the path of the key
+	IsPathPerKey(key string) bool
+}
+
 type Merger interface {
 	Merge(map[string]interface{}, map[string]interface{}) (map[string]interface{}, error)
 	IgnorePrefix() string
@@ -0,0 +1,427 @@
+package diskcache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// EnvKey is the envvar that contains the passphrase used to encrypt the cache entries
+	EnvKey = "VALS_CACHE_KEY"
+	// EnvKeyFile is the envvar that contains the path to the file whose content is used to encrypt the cache entries
+	EnvKeyFile = "VALS_CACHE_KEY_FILE"
+
+	// DefaultTTL is how long entries are kept when neither Options.TTL nor a provider-specific TTL is set
+	DefaultTTL = time.Hour
+
+	entrySuffix = ".cache"
+
+	// headerFile is the name of the file in the cache directory that contains the parameters of the derivation of
+	// the AES-256 key from the configured key, shared by all the processes using the directory
+	headerFile = "header.yaml"
+
+	// The scrypt parameters of new cache directories, which make guessing the configured key from the entries slow
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// header is the content of headerFile
+type header struct {
+	KDF string `yaml:"kdf"`
+	// Salt is the base64-encoded random salt of the cache directory
+	Salt string `yaml:"salt"`
+	N    int    `yaml:"n"`
+	R    int    `yaml:"r"`
+	P    int    `yaml:"p"`
+}
+
+// Options configures a Cache
+type Options struct {
+	// Dir is the directory to store the cache entries. Defaults to DefaultDir().
+	Dir string
+	// Key is the secret used to encrypt the cache entries. Any length is accepted as the AES-256 key is derived from it
+	// with scrypt and the random salt of the cache directory.
+	// Defaults to the content of the file at $VALS_CACHE_KEY_FILE, or $VALS_CACHE_KEY.
+	Key []byte
+	// TTL is how long entries are kept. Defaults to DefaultTTL.
+	TTL time.Duration
+	// ProviderTTLs overrides TTL for the entries of the providers with the URI schemes used as the keys
+	ProviderTTLs map[string]time.Duration
+}
+
+// Cache is a persistent cache that stores each entry as a file encrypted with AES-GCM.
+// Keys are expected to be ref URIs like `vault://foo/bar#/baz` so that the TTL can be chosen by the provider scheme.
+//
+// It is safe for concurrent use, including by multiple processes sharing the same directory.
+type Cache struct {
+	namespace    string
+	dir          string
+	aead         cipher.AEAD
+	ttl          time.Duration
+	providerTTLs map[string]time.Duration
+
+	now func() time.Time
+}
+
+// Entry is the decrypted content of a cache entry
+type Entry struct {
+	Namespace string      `yaml:"namespace,omitempty"`
+	Key       string      `yaml:"key"`
+	Provider  string      `yaml:"provider"`
+	Value     interface{} `yaml:"value"`
+	CreatedAt time.Time   `yaml:"createdAt"`
+	ExpiresAt time.Time   `yaml:"expiresAt"`
+}
+
+// DefaultDir returns the directory used by default to store the cache entries
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "vals"), nil
+}
+
+// KeyFromEnv reads the encryption key from $VALS_CACHE_KEY_FILE or $VALS_CACHE_KEY, in this order
+func KeyFromEnv() ([]byte, error) {
+	if f := os.Getenv(EnvKeyFile); f != "" {
+		bs, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("reading cache key file %s: %w", f, err)
+		}
+		return bs, nil
+	}
+	if k := os.Getenv(EnvKey); k != "" {
+		return []byte(k), nil
+	}
+	return nil, fmt.Errorf("no cache key is configured: set either %s or %s", EnvKeyFile, EnvKey)
+}
+
+// New returns a Cache that stores the entries in opts.Dir, creating the directory if necessary
+func New(opts Options) (*Cache, error) {
+	dir := opts.Dir
+	if dir == "" {
+		d, err := DefaultDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = d
+	}
+
+	key := opts.Key
+	if len(key) == 0 {
+		k, err := KeyFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	}
+
+	ttl := opts.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating cache directory: %w", err)
+	}
+
+	h, err := readOrCreateHeader(dir)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := base64.StdEncoding.DecodeString(h.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("decoding the salt of %s: %w", filepath.Join(dir, headerFile), err)
+	}
+	derived, err := scrypt.Key(key, salt, h.N, h.R, h.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("deriving the cache key: %w", err)
+	}
+
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cache{
+		dir:          dir,
+		aead:         aead,
+		ttl:          ttl,
+		providerTTLs: opts.ProviderTTLs,
+		now:          time.Now,
+	}, nil
+}
+
+// readOrCreateHeader returns the header of the cache directory, creating it with a random salt if it doesn't exist yet
+func readOrCreateHeader(dir string) (*header, error) {
+	path := filepath.Join(dir, headerFile)
+
+	h, err := readHeader(path)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return h, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	bs, err := yaml.Marshal(header{KDF: "scrypt", Salt: base64.StdEncoding.EncodeToString(salt), N: scryptN, R: scryptR, P: scryptP})
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	// Linking fails when another process created the header in the meantime, whose salt is used instead
+	if err := os.Link(tmp.Name(), path); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, err
+	}
+
+	return readHeader(path)
+}
+
+func readHeader(path string) (*header, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var h header
+	if err := yaml.Unmarshal(bs, &h); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	if h.KDF != "scrypt" {
+		return nil, fmt.Errorf("%s: unsupported key derivation function %q", path, h.KDF)
+	}
+	return &h, nil
+}
+
+// WithNamespace returns a Cache that shares the directory and the configuration with c,
+// but whose keys never collide with the keys of c or of the caches with other namespaces.
+func (c *Cache) WithNamespace(ns string) *Cache {
+	n := *c
+	n.namespace = ns
+	return &n
+}
+
+// Get returns the value for the key unless it is missing, expired, or cannot be decrypted with the configured key
+func (c *Cache) Get(key interface{}) (interface{}, bool) {
+	k := fmt.Sprintf("%v", key)
+
+	e, err := c.read(c.path(k))
+	if err != nil || e.Namespace != c.namespace || e.Key != k || !c.now().Before(e.ExpiresAt) {
+		return nil, false
+	}
+
+	return e.Value, true
+}
+
+// Add stores the value for the key. It never evicts other entries, and failures are silently ignored
+// as the cache is only an optimization.
+func (c *Cache) Add(key, value interface{}) bool {
+	k := fmt.Sprintf("%v", key)
+	provider := Provider(k)
+
+	ttl := c.ttl
+	if d, ok := c.providerTTLs[provider]; ok {
+		ttl = d
+	}
+	if ttl < 0 {
+		return false
+	}
+
+	now := c.now()
+	e := Entry{
+		Namespace: c.namespace,
+		Key:       k,
+		Provider:  provider,
+		Value:     value,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	_ = c.write(c.path(k), e)
+
+	return false
+}
+
+// Remove removes the entry for the key, and reports whether there was one
+func (c *Cache) Remove(key interface{}) bool {
+	err := os.Remove(c.path(fmt.Sprintf("%v", key)))
+	return err == nil
+}
+
+// Keys returns the keys of the entries of the namespace of c that can be decrypted with the configured key,
+// including the expired ones
+func (c *Cache) Keys() []interface{} {
+	entries, err := c.Entries()
+	if err != nil {
+		return nil
+	}
+
+	var keys []interface{}
+	for _, e := range entries {
+		if e.Namespace == c.namespace {
+			keys = append(keys, e.Key)
+		}
+	}
+	return keys
+}
+
+// Entries returns all the entries that can be decrypted with the configured key, sorted by key.
+// Entries of all the namespaces are returned.
+func (c *Cache) Entries() ([]Entry, error) {
+	files, err := c.files()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, f := range files {
+		e, err := c.read(f)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, *e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Key == entries[j].Key {
+			return entries[i].Namespace < entries[j].Namespace
+		}
+		return entries[i].Key < entries[j].Key
+	})
+
+	return entries, nil
+}
+
+// Clear removes all the entries of all the namespaces, or only the expired ones when expiredOnly is true.
+// Entries that cannot be decrypted with the configured key are considered expired.
+func (c *Cache) Clear(expiredOnly bool) (int, error) {
+	files, err := c.files()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, f := range files {
+		if expiredOnly {
+			e, err := c.read(f)
+			if err == nil && c.now().Before(e.ExpiresAt) {
+				continue
+			}
+		}
+		if err := os.Remove(f); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// Provider returns the provider scheme of the ref URI used as a cache key
+func Provider(key string) string {
+	if i := strings.Index(key, "://"); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+func (c *Cache) path(key string) string {
+	sum := sha256.Sum256([]byte(c.namespace + "\x00" + key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+entrySuffix)
+}
+
+func (c *Cache) files() ([]string, error) {
+	return filepath.Glob(filepath.Join(c.dir, "*"+entrySuffix))
+}
+
+func (c *Cache) read(path string) (*Entry, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := c.aead.NonceSize()
+	if len(bs) < nonceSize {
+		return nil, fmt.Errorf("cache entry %s is truncated", path)
+	}
+
+	plain, err := c.aead.Open(nil, bs[:nonceSize], bs[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting cache entry %s: %w", path, err)
+	}
+
+	var e Entry
+	if err := yaml.Unmarshal(plain, &e); err != nil {
+		return nil, fmt.Errorf("decoding cache entry %s: %w", path, err)
+	}
+
+	return &e, nil
+}
+
+func (c *Cache) write(path string, e Entry) error {
+	plain, err := yaml.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, plain, nil)
+
+	// Write to a temporary file and rename it so that concurrent readers never see a partially written entry
+	tmp, err := ioutil.TempFile(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(sealed); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,168 @@
+package diskcache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := New(Options{
+		Dir:          dir,
+		Key:          []byte("secret"),
+		TTL:          time.Minute,
+		ProviderTTLs: map[string]time.Duration{"vault": time.Hour, "echo": -1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	doc := map[string]interface{}{"foo": map[string]interface{}{"bar": "BAR", "baz": 1}}
+
+	c.Add("awsssm://foo/bar", "FOO")
+	c.Add("vault://secret/foo", doc)
+	c.Add("echo://foo", "ECHO")
+
+	if v, ok := c.Get("awsssm://foo/bar"); !ok || v != "FOO" {
+		t.Errorf("unexpected value: %v, %v", v, ok)
+	}
+	if v, ok := c.Get("vault://secret/foo"); !ok {
+		t.Errorf("missing value for vault://secret/foo")
+	} else if diff := cmp.Diff(doc, v); diff != "" {
+		t.Errorf("unexpected value: -(expected), +(got)\n%s", diff)
+	}
+	if _, ok := c.Get("echo://foo"); ok {
+		t.Errorf("expected the negative TTL to disable caching")
+	}
+
+	// Entries never contain the keys nor the values in plaintext
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, f := range files {
+		bs, _ := ioutil.ReadFile(f)
+		for _, s := range []string{"FOO", "awsssm", "BAR"} {
+			if bytes.Contains(bs, []byte(s)) {
+				t.Errorf("%s contains %q in plaintext", f, s)
+			}
+		}
+	}
+
+	if _, ok := c.WithNamespace("other").Get("awsssm://foo/bar"); ok {
+		t.Errorf("expected the entry to be missing in another namespace")
+	}
+
+	other, err := New(Options{Dir: dir, Key: []byte("another secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := other.Get("awsssm://foo/bar"); ok {
+		t.Errorf("expected the entry not to be readable with another key")
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	if _, ok := c.Get("awsssm://foo/bar"); ok {
+		t.Errorf("expected awsssm://foo/bar to be expired")
+	}
+	if _, ok := c.Get("vault://secret/foo"); !ok {
+		t.Errorf("expected vault://secret/foo not to be expired")
+	}
+
+	entries, err := c.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+	if diff := cmp.Diff([]string{"awsssm://foo/bar", "vault://secret/foo"}, keys); diff != "" {
+		t.Errorf("unexpected entries: -(expected), +(got)\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]interface{}{"awsssm://foo/bar", "vault://secret/foo"}, c.Keys()); diff != "" {
+		t.Errorf("unexpected keys: -(expected), +(got)\n%s", diff)
+	}
+	if keys := c.WithNamespace("other").Keys(); len(keys) != 0 {
+		t.Errorf("unexpected keys in another namespace: %v", keys)
+	}
+
+	n, err := c.Clear(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("unexpected number of removed entries: expected=1, got=%d", n)
+	}
+
+	if !c.Remove("vault://secret/foo") {
+		t.Errorf("expected vault://secret/foo to be removed")
+	}
+	if _, ok := c.Get("vault://secret/foo"); ok {
+		t.Errorf("expected vault://secret/foo to be missing once removed")
+	}
+	if c.Remove("vault://secret/foo") {
+		t.Errorf("expected nothing to be removed twice")
+	}
+	c.Add("vault://secret/foo", doc)
+
+	n, err = c.Clear(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("unexpected number of removed entries: expected=1, got=%d", n)
+	}
+}
+
+func TestCache_Salt(t *testing.T) {
+	dir, otherDir := t.TempDir(), t.TempDir()
+
+	c, err := New(Options{Dir: dir, Key: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Add("awsssm://foo/bar", "FOO")
+
+	// The caches of the same directory share the salt
+	reopened, err := New(Options{Dir: dir, Key: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := reopened.Get("awsssm://foo/bar"); !ok || v != "FOO" {
+		t.Errorf("unexpected value of the reopened cache: %v, %v", v, ok)
+	}
+
+	h, err := readHeader(filepath.Join(dir, headerFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.KDF != "scrypt" || h.Salt == "" || h.N != scryptN {
+		t.Errorf("unexpected header: %+v", h)
+	}
+
+	// The entries can't be decrypted with the same key in other directories, whose salts differ
+	other, err := New(Options{Dir: otherDir, Key: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := c.path("awsssm://foo/bar")
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(otherDir, filepath.Base(path)), bs, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := other.Get("awsssm://foo/bar"); ok {
+		t.Errorf("expected the entry not to be readable with the salt of another directory")
+	}
+}
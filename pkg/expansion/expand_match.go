@@ -1,9 +1,12 @@
 package expansion
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
+
+	"github.com/kroonprins/vals/pkg/api"
 )
 
 type ExpandRegexMatch struct {
@@ -12,38 +15,62 @@ type ExpandRegexMatch struct {
 	Only   []string
 }
 
-var DefaultRefRegexp = regexp.MustCompile(`((secret)?ref)\+([^\+:]*://[^\+]+)\+?`)
+// DefaultRefRegexp matches refs like `ref+vault://foo/bar#/baz`, and optional refs marked with `?` like `ref?+vault://foo/bar#/baz`
+var DefaultRefRegexp = regexp.MustCompile(`((secret)?ref)\??\+([^\+:]*://[^\+]+)\+?`)
+
+// Omit is the value of optional refs whose secrets don't exist.
+// ModifyStringValues removes the map entries and the list items whose values are Omit.
+var Omit = omitted{}
 
+type omitted struct{}
+
+// InString replaces the refs in the string with their values.
+// A ref that evaluates to a map results in the map, and a ref that evaluates to another scalar like a number,
+// a boolean or null results in the scalar with its type, as long as the ref makes up the whole string.
+// Otherwise scalars are formatted as they would be in YAML.
+//
+// The whole string results in Omit when an optional ref in it, like `ref?+vault://foo/bar`, fails with api.ErrNotFound,
+// or when the lookup of a ref returns Omit.
+//
+// Nested refs in the refs, like `ref+vault://secret/{{ref+awsssm://team/vault-path}}#/pw`,
+// are resolved before the refs are looked up.
 func (e *ExpandRegexMatch) InString(s string) (interface{}, error) {
 	var sb strings.Builder
 	res := make(map[string]interface{})
+	whole := s
 	for {
-		ixs := e.Target.FindStringSubmatchIndex(s)
+		ixs := e.find(s)
 		if ixs == nil {
 			sb.WriteString(s)
 			break
 		}
 		kind := s[ixs[2]:ixs[3]]
-		if len(e.Only) > 0 {
-			var shouldExpand bool
-			for _, k := range e.Only {
-				if k == kind {
-					shouldExpand = true
-					break
+		if !e.shouldExpand(kind) {
+			sb.WriteString(s)
+			break
+		}
+		ref := s[ixs[6]:ixs[7]]
+		if HasNested(ref) {
+			resolved, err := e.ResolveNested(ref)
+			if err != nil {
+				if isOptional(s, ixs) && errors.Is(err, api.ErrNotFound) {
+					return Omit, nil
 				}
+				return nil, fmt.Errorf("expand %s: %w", ref, err)
 			}
-			if !shouldExpand {
-				sb.WriteString(s)
-				break
-			}
+			ref = resolved
 		}
-		ref := s[ixs[6]:ixs[7]]
 		val, err := e.Lookup(ref)
 		if err != nil {
-			return nil, fmt.Errorf("expand %s: %v", ref, err)
+			if isOptional(s, ixs) && errors.Is(err, api.ErrNotFound) {
+				return Omit, nil
+			}
+			return nil, fmt.Errorf("expand %s: %w", ref, err)
 		}
 
 		switch typed_val := val.(type) {
+		case omitted:
+			return Omit, nil
 		case string:
 			sb.WriteString(s[:ixs[0]])
 			sb.WriteString(typed_val)
@@ -55,8 +82,14 @@ func (e *ExpandRegexMatch) InString(s string) (interface{}, error) {
 			for k, v := range typed_val {
 				res[fmt.Sprintf("%v", k)] = v
 			}
+		case []interface{}:
+			return nil, fmt.Errorf("unexpected output format for %s: %T", ref, val)
 		default:
-			return nil, fmt.Errorf("unexpected output format for %s: %v", ref, err)
+			if ixs[0] == 0 && ixs[1] == len(s) && s == whole {
+				return val, nil
+			}
+			sb.WriteString(s[:ixs[0]])
+			sb.WriteString(FormatScalar(val))
 		}
 
 		s = s[ixs[1]:]
@@ -91,3 +124,100 @@ func (e *ExpandRegexMatch) InMap(target map[string]interface{}) (map[string]inte
 		return nil, fmt.Errorf("unexpected type: %v: %T", ret, ret)
 	}
 }
+
+// FormatScalar returns the string representation of the scalar as it would be written in YAML, like `true`, `8080` and `null`
+func FormatScalar(v interface{}) string {
+	if v == nil {
+		return "null"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// Refs returns the refs contained in the keys and the string values of the target, in the order of appearance
+// for strings and slices, and in no particular order for maps.
+// The refs are returned without the `ref+` prefix, as passed to Lookup.
+func (e *ExpandRegexMatch) Refs(target interface{}) []string {
+	var refs []string
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch typed := v.(type) {
+		case string:
+			refs = append(refs, e.refsInString(typed)...)
+		case map[string]interface{}:
+			for k, v := range typed {
+				walk(k)
+				walk(v)
+			}
+		case map[interface{}]interface{}:
+			for k, v := range typed {
+				walk(fmt.Sprintf("%v", k))
+				walk(v)
+			}
+		case []interface{}:
+			for _, v := range typed {
+				walk(v)
+			}
+		case []string:
+			for _, v := range typed {
+				walk(v)
+			}
+		}
+	}
+
+	walk(target)
+
+	return refs
+}
+
+func (e *ExpandRegexMatch) refsInString(s string) []string {
+	var refs []string
+	for _, m := range e.Matches(s) {
+		refs = append(refs, m.Ref)
+	}
+	return refs
+}
+
+// Match is a ref found in a string
+type Match struct {
+	// Kind is either `ref` or `secretref`
+	Kind string
+	// Ref is the ref without the kind prefix, like `vault://foo/bar#/baz`
+	Ref string
+	// Optional is true for refs marked with `?`, like `ref?+vault://foo/bar#/baz`
+	Optional bool
+}
+
+// Matches returns the refs in the string in the order of appearance, respecting Only as InString does
+func (e *ExpandRegexMatch) Matches(s string) []Match {
+	var matches []Match
+	for {
+		ixs := e.find(s)
+		if ixs == nil {
+			return matches
+		}
+		kind := s[ixs[2]:ixs[3]]
+		if !e.shouldExpand(kind) {
+			return matches
+		}
+		matches = append(matches, Match{Kind: kind, Ref: s[ixs[6]:ixs[7]], Optional: isOptional(s, ixs)})
+		s = s[ixs[1]:]
+	}
+}
+
+// isOptional returns true if the kind of the ref matched at ixs is followed by the `?` marker
+func isOptional(s string, ixs []int) bool {
+	return ixs[3] < len(s) && s[ixs[3]] == '?'
+}
+
+func (e *ExpandRegexMatch) shouldExpand(kind string) bool {
+	if len(e.Only) == 0 {
+		return true
+	}
+	for _, k := range e.Only {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
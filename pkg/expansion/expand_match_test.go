@@ -1,10 +1,14 @@
 package expansion
 
 import (
+	"errors"
+	"fmt"
 	"net/url"
 	"reflect"
 	"regexp"
 	"testing"
+
+	"github.com/kroonprins/vals/pkg/api"
 )
 
 func TestExpandRegexpMatchInString(t *testing.T) {
@@ -202,3 +206,170 @@ func TestExpandRegexpMatchInMap(t *testing.T) {
 		})
 	}
 }
+
+func TestExpandRegexpMatchRefs(t *testing.T) {
+	testcases := []struct {
+		name     string
+		only     []string
+		input    interface{}
+		expected []string
+	}{
+		{
+			name:     "string",
+			input:    "ref+vault://srv/foo/bar",
+			expected: []string{"vault://srv/foo/bar"},
+		},
+		{
+			name:     "multiple refs in string",
+			input:    "x-ref+vault://srv/foo+-secretref+echo://bar+",
+			expected: []string{"vault://srv/foo", "echo://bar"},
+		},
+		{
+			name:     "only ref",
+			only:     []string{"ref"},
+			input:    []interface{}{"ref+vault://srv/foo", "secretref+echo://bar"},
+			expected: []string{"vault://srv/foo"},
+		},
+		{
+			name:     "nested",
+			input:    map[string]interface{}{"k": map[interface{}]interface{}{"k2": []string{"ref+vault://srv/foo/bar"}}},
+			expected: []string{"vault://srv/foo/bar"},
+		},
+		{
+			name:     "key",
+			input:    map[string]interface{}{"ref+echo://foo/bar": "baz"},
+			expected: []string{"echo://foo/bar"},
+		},
+	}
+
+	for i := range testcases {
+		tc := testcases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			expand := ExpandRegexMatch{
+				Target: DefaultRefRegexp,
+				Only:   tc.only,
+			}
+
+			actual := expand.Refs(tc.input)
+
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("unexpected result: expected:\n%v\ngot:%v\n", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestExpandRegexpMatchMatches(t *testing.T) {
+	expand := ExpandRegexMatch{
+		Target: DefaultRefRegexp,
+	}
+
+	actual := expand.Matches("x-ref+vault://srv/foo+-secretref+echo://bar+")
+	expected := []Match{
+		{Kind: "ref", Ref: "vault://srv/foo"},
+		{Kind: "secretref", Ref: "echo://bar"},
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected result: expected:\n%v\ngot:%v\n", expected, actual)
+	}
+}
+
+func TestExpandRegexpMatchInString_Scalars(t *testing.T) {
+	values := map[string]interface{}{
+		"echo://port":  8080,
+		"echo://debug": true,
+		"echo://none":  nil,
+		"echo://list":  []interface{}{"a"},
+	}
+
+	expand := ExpandRegexMatch{
+		Target: DefaultRefRegexp,
+		Lookup: func(m string) (interface{}, error) {
+			return values[m], nil
+		},
+	}
+
+	testcases := []struct {
+		input    string
+		expected interface{}
+		err      bool
+	}{
+		{input: "ref+echo://port", expected: 8080},
+		{input: "ref+echo://port+", expected: 8080},
+		{input: "ref+echo://debug", expected: true},
+		{input: "ref+echo://none", expected: nil},
+		{input: "host:ref+echo://port", expected: "host:8080"},
+		{input: "ref+echo://debug+-ref+echo://none", expected: "true-null"},
+		{input: "ref+echo://list", err: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.input, func(t *testing.T) {
+			actual, err := expand.InString(tc.input)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error, got %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("unexpected result: expected %v(%T), got %v(%T)", tc.expected, tc.expected, actual, actual)
+			}
+		})
+	}
+}
+
+func TestExpandRegexpMatchInMap_Optional(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	expand := ExpandRegexMatch{
+		Target: DefaultRefRegexp,
+		Lookup: func(m string) (interface{}, error) {
+			switch m {
+			case "echo://found":
+				return "foo", nil
+			case "echo://failed":
+				return nil, errFailed
+			default:
+				return nil, api.NotFound(fmt.Errorf("no value for %s", m))
+			}
+		},
+	}
+
+	actual, err := expand.InMap(map[string]interface{}{
+		"found":               "ref?+echo://found",
+		"missing":             "ref?+echo://missing",
+		"ref?+echo://missing": "key",
+		"list": []interface{}{
+			"ref?+echo://found",
+			"prefix-ref?+echo://missing+",
+		},
+		"nested": map[string]interface{}{
+			"missing": "ref?+echo://missing",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"found":  "foo",
+		"list":   []interface{}{"foo"},
+		"nested": map[string]interface{}{},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected result: expected:\n%v\ngot:%v\n", expected, actual)
+	}
+
+	if _, err := expand.InString("ref+echo://missing"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("unexpected error for the required ref: %v", err)
+	}
+	if _, err := expand.InString("ref?+echo://failed"); !errors.Is(err, errFailed) {
+		t.Errorf("unexpected error for the optional ref: %v", err)
+	}
+}
@@ -12,6 +12,15 @@ func ModifyStringValues(v interface{}, f func(path string) (interface{}, error))
 		if err != nil {
 			return false, err
 		}
+		switch k2.(type) {
+		case omitted:
+			// The entry is removed
+			return true, nil
+		case string, map[string]interface{}, map[interface{}]interface{}:
+		default:
+			// Keys are strings even if they evaluate to other scalars like numbers
+			k2 = FormatScalar(k2)
+		}
 		switch typed_k2 := k2.(type) {
 		case string:
 			if typed_k2 == k {
@@ -64,7 +73,7 @@ func ModifyStringValues(v interface{}, f func(path string) (interface{}, error))
 			return nil, err
 		}
 		switch modified.(type) {
-		case string:
+		case string, omitted:
 			return modified, err
 		default:
 			return ModifyStringValues(modified, f)
@@ -90,6 +99,10 @@ func ModifyStringValues(v interface{}, f func(path string) (interface{}, error))
 			if err != nil {
 				return nil, err
 			}
+			if v2 == Omit {
+				deleted = append(deleted, k)
+				continue
+			}
 			strmap[k] = v2
 		}
 		for _, k := range deleted {
@@ -116,6 +129,10 @@ func ModifyStringValues(v interface{}, f func(path string) (interface{}, error))
 			if err != nil {
 				return nil, err
 			}
+			if v2 == Omit {
+				deleted = append(deleted, k)
+				continue
+			}
 			typed_v[k] = v2
 		}
 		for _, k := range deleted {
@@ -132,6 +149,9 @@ func ModifyStringValues(v interface{}, f func(path string) (interface{}, error))
 			if err != nil {
 				return nil, err
 			}
+			if res == Omit {
+				continue
+			}
 			a = append(a, res)
 		}
 		casted_v = a
@@ -142,6 +162,9 @@ func ModifyStringValues(v interface{}, f func(path string) (interface{}, error))
 			if err != nil {
 				return nil, err
 			}
+			if res == Omit {
+				continue
+			}
 			a = append(a, res)
 		}
 		casted_v = a
@@ -0,0 +1,128 @@
+package expansion
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kroonprins/vals/pkg/api"
+)
+
+// Nested refs are refs in the URIs of other refs, enclosed in double braces like
+// `ref+vault://secret/{{ref+awsssm://team/vault-path}}#/pw`.
+// They are resolved inner-first, and their values replace the double braces before the outer refs are looked up.
+
+// HasNested returns true if the ref contains nested refs
+func HasNested(ref string) bool {
+	return strings.Contains(ref, "{{")
+}
+
+// NestedRefs returns the contents of the outermost double braces in the ref, like `ref+awsssm://team/vault-path`
+func NestedRefs(ref string) ([]string, error) {
+	var nested []string
+	_, err := ReplaceNested(ref, func(inner string) (string, error) {
+		nested = append(nested, inner)
+		return "", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nested, nil
+}
+
+// ReplaceNested replaces the outermost double braces in the ref with the results of f,
+// which is called with the contents of the braces
+func ReplaceNested(ref string, f func(inner string) (string, error)) (string, error) {
+	var (
+		sb    strings.Builder
+		depth int
+		start int
+		last  int
+	)
+
+	for i := 0; i < len(ref); {
+		switch {
+		case strings.HasPrefix(ref[i:], "{{"):
+			if depth == 0 {
+				sb.WriteString(ref[last:i])
+				start = i + 2
+			}
+			depth++
+			i += 2
+		case depth > 0 && strings.HasPrefix(ref[i:], "}}"):
+			depth--
+			if depth == 0 {
+				v, err := f(ref[start:i])
+				if err != nil {
+					return "", err
+				}
+				sb.WriteString(v)
+				last = i + 2
+			}
+			i += 2
+		default:
+			i++
+		}
+	}
+
+	if depth > 0 {
+		return "", fmt.Errorf("unterminated nested ref in %s", ref)
+	}
+
+	sb.WriteString(ref[last:])
+
+	return sb.String(), nil
+}
+
+// ResolveNested returns the ref with the nested refs replaced with their values.
+// The values must be scalars. They are substituted literally: double braces in the values aren't resolved,
+// so that only the refs written in the ref itself are looked up, and not the ones that values could smuggle in.
+func (e *ExpandRegexMatch) ResolveNested(ref string) (string, error) {
+	return ReplaceNested(ref, func(inner string) (string, error) {
+		v, err := e.InString(inner)
+		if err != nil {
+			return "", err
+		}
+		switch v.(type) {
+		case omitted:
+			return "", api.NotFound(fmt.Errorf("nested ref %s has no value", inner))
+		case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+			return "", fmt.Errorf("nested ref %s: unexpected type of value %T: nested refs must evaluate to scalars", inner, v)
+		}
+		return FormatScalar(v), nil
+	})
+}
+
+// find returns the indices of the submatches of the first ref in s, like Target.FindStringSubmatchIndex does,
+// but extends the ref over the nested refs, which contain `+` that otherwise ends the ref
+func (e *ExpandRegexMatch) find(s string) []int {
+	ixs := e.Target.FindStringSubmatchIndex(s)
+	if ixs == nil || len(ixs) < 8 || ixs[6] < 0 || !HasNested(s[ixs[6]:ixs[7]]) {
+		return ixs
+	}
+
+	end := ixs[6]
+	depth := 0
+scan:
+	for end < len(s) {
+		switch {
+		case strings.HasPrefix(s[end:], "{{"):
+			depth++
+			end += 2
+		case depth > 0 && strings.HasPrefix(s[end:], "}}"):
+			depth--
+			end += 2
+		case depth == 0 && s[end] == '+':
+			break scan
+		default:
+			end++
+		}
+	}
+
+	ixs[7] = end
+	ixs[1] = end
+	if end < len(s) && s[end] == '+' {
+		ixs[1]++
+	}
+
+	return ixs
+}
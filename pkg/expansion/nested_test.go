@@ -0,0 +1,128 @@
+package expansion
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kroonprins/vals/pkg/api"
+)
+
+func TestExpandRegexpMatchInString_Nested(t *testing.T) {
+	values := map[string]interface{}{
+		"test://path":         "team/db",
+		"test://port":         5432,
+		"test://team/db#pw":   "secret",
+		"test://team/db/5432": "pgsql",
+		"test://map":          map[string]interface{}{"k": "v"},
+		"test://which":        "path",
+		"test://indirect":     "{{ref+test://path}}",
+	}
+
+	var looked []string
+	expand := ExpandRegexMatch{
+		Target: DefaultRefRegexp,
+		Lookup: func(m string) (interface{}, error) {
+			looked = append(looked, m)
+			v, ok := values[m]
+			if !ok {
+				return nil, api.NotFound(fmt.Errorf("no value for %s", m))
+			}
+			return v, nil
+		},
+	}
+
+	testcases := []struct {
+		input    string
+		expected interface{}
+		looked   []string
+		err      string
+	}{
+		{
+			input:    "ref+test://{{ref+test://path}}#pw",
+			expected: "secret",
+			looked:   []string{"test://path", "test://team/db#pw"},
+		},
+		{
+			input:    "x-ref+test://{{ref+test://path}}/{{ref+test://port}}+-y",
+			expected: "x-pgsql-y",
+			looked:   []string{"test://path", "test://port", "test://team/db/5432"},
+		},
+		{
+			input:    "ref+test://{{ref+test://{{ref+test://which}}}}#pw",
+			expected: "secret",
+			looked:   []string{"test://which", "test://path", "test://team/db#pw"},
+		},
+		{
+			// The refs in the values of nested refs are substituted as is instead of being looked up
+			input:  "ref+test://{{ref+test://indirect}}#pw",
+			err:    "no value for test://{{ref+test://path}}#pw",
+			looked: []string{"test://indirect", "test://{{ref+test://path}}#pw"},
+		},
+		{
+			input:    "ref?+test://{{ref?+test://missing}}",
+			expected: Omit,
+		},
+		{
+			input: "ref+test://{{ref+test://map}}",
+			err:   "nested refs must evaluate to scalars",
+		},
+		{
+			input: "ref+test://{{ref+test://path",
+			err:   "unterminated nested ref",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.input, func(t *testing.T) {
+			looked = nil
+
+			actual, err := expand.InString(tc.input)
+			if tc.looked != nil && !reflect.DeepEqual(tc.looked, looked) {
+				t.Errorf("unexpected lookups: expected %v, got %v", tc.looked, looked)
+			}
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("unexpected error: expected %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("unexpected result: expected %v(%T), got %v(%T)", tc.expected, tc.expected, actual, actual)
+			}
+		})
+	}
+
+	if _, err := expand.InString("ref+test://{{ref+test://missing}}"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("unexpected error for the missing nested ref: %v", err)
+	}
+}
+
+func TestExpandRegexpMatchMatches_Nested(t *testing.T) {
+	expand := ExpandRegexMatch{
+		Target: DefaultRefRegexp,
+	}
+
+	actual := expand.Matches("ref+vault://{{ref+awsssm://addr}}/secret/{{ref?+awsssm://team/{{ref+echo://x}}}}#/pw+:ref+echo://y")
+	expected := []Match{
+		{Kind: "ref", Ref: "vault://{{ref+awsssm://addr}}/secret/{{ref?+awsssm://team/{{ref+echo://x}}}}#/pw"},
+		{Kind: "ref", Ref: "echo://y"},
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected result: expected:\n%v\ngot:%v\n", expected, actual)
+	}
+
+	nested, err := NestedRefs(expected[0].Ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := []string{"ref+awsssm://addr", "ref?+awsssm://team/{{ref+echo://x}}"}; !reflect.DeepEqual(diff, nested) {
+		t.Errorf("unexpected nested refs: expected %v, got %v", diff, nested)
+	}
+}
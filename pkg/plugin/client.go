@@ -0,0 +1,283 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/kroonprins/vals/pkg/api"
+)
+
+// Provider is the api.Provider backed by a plugin.
+// The executable of the plugin is started on the first lookup, and restarted on the next lookup if it exits.
+type Provider struct {
+	name   string
+	path   string
+	config map[string]interface{}
+
+	mu   sync.Mutex
+	proc *process
+}
+
+// New returns the provider backed by the `vals-provider-<name>` executable on PATH,
+// which is configured with the config, usually the query parameters of the ref
+func New(name string, cfg api.StaticConfig) (*Provider, error) {
+	exe := ExecutablePrefix + name
+	path, err := exec.LookPath(exe)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %q: %w", exe, err)
+	}
+
+	config := map[string]interface{}{}
+	if cfg != nil {
+		for k, v := range cfg.Map() {
+			config[k] = v
+		}
+	}
+
+	return &Provider{name: exe, path: path, config: config}, nil
+}
+
+func (p *Provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *Provider) GetStringContext(ctx context.Context, key string) (string, error) {
+	var res GetStringResult
+	if err := p.call(ctx, MethodGetString, GetParams{Key: key}, &res); err != nil {
+		return "", err
+	}
+	return res.Value, nil
+}
+
+func (p *Provider) GetStringMap(key string) (map[string]interface{}, error) {
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *Provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	var res GetStringMapResult
+	if err := p.call(ctx, MethodGetStringMap, GetParams{Key: key}, &res); err != nil {
+		return nil, err
+	}
+	return res.Value, nil
+}
+
+// Close stops the executable of the plugin, if it's running
+func (p *Provider) Close() error {
+	p.mu.Lock()
+	proc := p.proc
+	p.proc = nil
+	p.mu.Unlock()
+
+	if proc == nil {
+		return nil
+	}
+	return proc.close()
+}
+
+func (p *Provider) call(ctx context.Context, method string, params, result interface{}) error {
+	proc, err := p.process(ctx)
+	if err != nil {
+		return err
+	}
+	if err := proc.call(ctx, method, params, result); err != nil {
+		return fmt.Errorf("%s: %w", p.name, err)
+	}
+	return nil
+}
+
+// process returns the running process of the plugin, starting it if needed
+func (p *Provider) process(ctx context.Context) (*process, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.proc != nil {
+		select {
+		case <-p.proc.done:
+			p.proc = nil
+		default:
+			return p.proc, nil
+		}
+	}
+
+	proc, err := start(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.name, err)
+	}
+
+	var res InitResult
+	if err := proc.call(ctx, MethodInit, InitParams{ProtocolVersion: ProtocolVersion, Config: p.config}, &res); err != nil {
+		proc.close()
+		return nil, fmt.Errorf("%s: initializing: %w", p.name, err)
+	}
+	if res.ProtocolVersion != ProtocolVersion {
+		proc.close()
+		return nil, fmt.Errorf("%s: unsupported protocol version %d: expected %d", p.name, res.ProtocolVersion, ProtocolVersion)
+	}
+
+	p.proc = proc
+	return proc, nil
+}
+
+// process is a running executable of a plugin
+type process struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	writeMu sync.Mutex
+	enc     *json.Encoder
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan Response
+	err     error
+	done    chan struct{}
+}
+
+func start(path string) (*process, error) {
+	cmd := exec.Command(path)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	proc := &process{
+		cmd:     cmd,
+		stdin:   stdin,
+		enc:     json.NewEncoder(stdin),
+		pending: map[uint64]chan Response{},
+		done:    make(chan struct{}),
+	}
+	go proc.read(stdout)
+
+	return proc, nil
+}
+
+// read dispatches the responses to the pending requests until the plugin exits
+func (p *process) read(stdout io.Reader) {
+	dec := json.NewDecoder(stdout)
+	var err error
+	for {
+		var res Response
+		if err = dec.Decode(&res); err != nil {
+			break
+		}
+
+		p.mu.Lock()
+		ch, ok := p.pending[res.ID]
+		delete(p.pending, res.ID)
+		p.mu.Unlock()
+
+		// The responses to the requests given up on are discarded
+		if ok {
+			ch <- res
+		}
+	}
+
+	if err == io.EOF {
+		err = errors.New("the plugin exited")
+	} else {
+		err = fmt.Errorf("reading the response: %w", err)
+	}
+	if waitErr := p.cmd.Wait(); waitErr != nil {
+		err = fmt.Errorf("%v: %w", err, waitErr)
+	}
+
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+	close(p.done)
+}
+
+func (p *process) call(ctx context.Context, method string, params, result interface{}) error {
+	// The request can't be sent once the plugin exited
+	select {
+	case <-p.done:
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.err
+	default:
+	}
+
+	bs, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan Response, 1)
+
+	p.mu.Lock()
+	p.nextID++
+	id := p.nextID
+	p.pending[id] = ch
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+	}()
+
+	p.writeMu.Lock()
+	err = p.enc.Encode(Request{ID: id, Method: method, Params: bs})
+	p.writeMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("sending the request: %w", err)
+	}
+
+	select {
+	case res := <-ch:
+		return decodeResponse(res, result)
+	case <-p.done:
+		// The plugin may have responded right before exiting
+		select {
+		case res := <-ch:
+			return decodeResponse(res, result)
+		default:
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func decodeResponse(res Response, result interface{}) error {
+	if res.Error != nil {
+		err := errors.New(res.Error.Message)
+		if res.Error.NotFound {
+			err = api.NotFound(err)
+		}
+		return err
+	}
+	return json.Unmarshal(res.Result, result)
+}
+
+// close closes the STDIN of the plugin, which makes it exit, and kills it unless it exits in time
+func (p *process) close() error {
+	p.stdin.Close()
+
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+	return nil
+}
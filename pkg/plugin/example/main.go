@@ -0,0 +1,54 @@
+// Command vals-provider-example is the reference plugin of vals, used as `ref+plugin-example://<key>`.
+//
+// It returns the key prefixed with the `prefix` query parameter, like the echo provider,
+// and reports the keys starting with `missing` as not found.
+//
+// Build it with `go build -o vals-provider-example ./pkg/plugin/example` and put it on PATH to use it.
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kroonprins/vals/pkg/api"
+	"github.com/kroonprins/vals/pkg/plugin"
+)
+
+type provider struct {
+	prefix string
+}
+
+func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
+	if strings.HasPrefix(key, "missing") {
+		return "", api.NotFound(fmt.Errorf("key %q not found", key))
+	}
+	return p.prefix + key, nil
+}
+
+func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	if strings.HasPrefix(key, "missing") {
+		return nil, api.NotFound(fmt.Errorf("key %q not found", key))
+	}
+
+	m := map[string]interface{}{}
+	for _, kv := range strings.Split(key, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		m[k] = p.prefix + v
+	}
+	return m, nil
+}
+
+func main() {
+	plugin.Serve(func(cfg api.StaticConfig) (api.Provider, error) {
+		return &provider{prefix: cfg.String("prefix")}, nil
+	})
+}
@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kroonprins/vals/pkg/api"
+	"github.com/kroonprins/vals/pkg/config"
+)
+
+// buildExample builds the reference plugin into a temporary directory and puts it on PATH
+func buildExample(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	cmd := exec.Command("go", "build", "-o", filepath.Join(dir, ExecutablePrefix+"example"), "./example")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building the example plugin: %v\n%s", err, out)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestProvider(t *testing.T) {
+	buildExample(t)
+
+	p, err := New("example", config.Map(map[string]interface{}{"prefix": "x-"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s, err := p.GetStringContext(ctx, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s != "x-foo/bar" {
+		t.Errorf("unexpected value: expected=%q, got=%q", "x-foo/bar", s)
+	}
+
+	m, err := p.GetStringMapContext(ctx, "foo=1,bar=2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := map[string]interface{}{"foo": "x-1", "bar": "x-2"}; !reflect.DeepEqual(m, expected) {
+		t.Errorf("unexpected map: expected=%v, got=%v", expected, m)
+	}
+
+	_, err = p.GetStringContext(ctx, "missing")
+	if !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+	if expected := `vals-provider-example: key "missing" not found`; err == nil || err.Error() != expected {
+		t.Errorf("unexpected error: expected=%q, got=%v", expected, err)
+	}
+
+	// The plugin is restarted once it exits
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = p.GetStringContext(ctx, "baz")
+	if err != nil {
+		t.Fatalf("unexpected error after restarting the plugin: %v", err)
+	}
+	if s != "x-baz" {
+		t.Errorf("unexpected value: expected=%q, got=%q", "x-baz", s)
+	}
+}
+
+func TestProvider_Concurrent(t *testing.T) {
+	buildExample(t)
+
+	p, err := New("example", config.Map(map[string]interface{}{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		key := strings.Repeat("k", i+1)
+		go func() {
+			v, err := p.GetString(key)
+			if err == nil && v != key {
+				err = errors.New("unexpected value " + v + " for " + key)
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestProcess_CallAfterExit(t *testing.T) {
+	buildExample(t)
+
+	path, err := exec.LookPath(ExecutablePrefix + "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := start(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var res GetStringResult
+	err = proc.call(context.Background(), MethodGetString, GetParams{Key: "foo"}, &res)
+	if err == nil || !strings.HasPrefix(err.Error(), "the plugin exited") {
+		t.Errorf("expected the error of the exited plugin, got %v", err)
+	}
+}
+
+func TestNew_NotFound(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	_, err := New("nonexistent", config.Map(map[string]interface{}{}))
+	if err == nil || !strings.Contains(err.Error(), `"vals-provider-nonexistent"`) {
+		t.Errorf("expected an error about the missing executable, got %v", err)
+	}
+}
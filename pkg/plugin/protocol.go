@@ -0,0 +1,86 @@
+// Package plugin implements providers that run out of process, as executables named `vals-provider-<name>` on PATH.
+//
+// vals starts the executable of a plugin once per provider configuration, and talks to it over its STDIN and STDOUT
+// with JSON messages, one per line. Each request has an `id` that the plugin repeats in the response,
+// so that the plugin can serve requests concurrently and respond in any order:
+//
+//	-> {"id":1,"method":"init","params":{"protocol_version":1,"config":{"region":"us-east-1"}}}
+//	<- {"id":1,"result":{"protocol_version":1}}
+//	-> {"id":2,"method":"get_string","params":{"key":"foo/bar"}}
+//	<- {"id":2,"result":{"value":"baz"}}
+//	-> {"id":3,"method":"get_string_map","params":{"key":"foo"}}
+//	<- {"id":3,"error":{"message":"secret foo not found","not_found":true}}
+//
+// The first request is always `init`, with the query parameters of the ref as the config.
+// The plugin exits once its STDIN is closed. Anything it writes to STDERR is passed through to the STDERR of vals.
+//
+// Plugins written in Go only need to call Serve with the function that creates their provider.
+package plugin
+
+import (
+	"encoding/json"
+)
+
+const (
+	// ProtocolVersion is the version of the protocol spoken by this package.
+	// It is incremented on incompatible changes, and vals refuses to use plugins that respond with other versions.
+	ProtocolVersion = 1
+
+	// ExecutablePrefix is the prefix of the names of the executables of plugins, followed by the name of the plugin
+	ExecutablePrefix = "vals-provider-"
+	// SchemePrefix is the prefix of the schemes that always refer to plugins, like `ref+plugin-mystore://`.
+	// Schemes of no built-in or registered provider refer to plugins too, but the prefix avoids the clashes with future providers.
+	SchemePrefix = "plugin-"
+
+	MethodInit         = "init"
+	MethodGetString    = "get_string"
+	MethodGetStringMap = "get_string_map"
+)
+
+// Request is a request from vals to the plugin
+type Request struct {
+	ID     uint64          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is the response of the plugin to the request with the same ID.
+// Either Result or Error is set.
+type Response struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+// Error is the failure of a request
+type Error struct {
+	Message string `json:"message"`
+	// NotFound is set when the requested secret or key doesn't exist, which makes optional refs and defaults apply
+	NotFound bool `json:"not_found,omitempty"`
+}
+
+// InitParams is the params of the init request
+type InitParams struct {
+	ProtocolVersion int                    `json:"protocol_version"`
+	Config          map[string]interface{} `json:"config"`
+}
+
+// InitResult is the result of the init request
+type InitResult struct {
+	ProtocolVersion int `json:"protocol_version"`
+}
+
+// GetParams is the params of the get_string and get_string_map requests
+type GetParams struct {
+	Key string `json:"key"`
+}
+
+// GetStringResult is the result of the get_string request
+type GetStringResult struct {
+	Value string `json:"value"`
+}
+
+// GetStringMapResult is the result of the get_string_map request
+type GetStringMapResult struct {
+	Value map[string]interface{} `json:"value"`
+}
@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/kroonprins/vals/pkg/api"
+	"github.com/kroonprins/vals/pkg/config"
+)
+
+// EnvFallbackPrefix is the prefix of the environment variables that the config of the provider falls back to,
+// like `VALS_REGION` for `region`, as it does for the built-in providers
+const EnvFallbackPrefix = "VALS_"
+
+// Factory creates the provider of a plugin from the config sent by vals, which is the query parameters of the ref
+type Factory func(cfg api.StaticConfig) (api.Provider, error)
+
+// Serve serves the provider created by the factory over STDIN and STDOUT until STDIN is closed, and then exits.
+// It's meant to be called from the main function of plugins:
+//
+//	func main() {
+//		plugin.Serve(func(cfg api.StaticConfig) (api.Provider, error) {
+//			return mystore.New(cfg.String("region")), nil
+//		})
+//	}
+//
+// os.Stdout is redirected to STDERR while serving, so that the output of the provider doesn't break the protocol.
+func Serve(f Factory) {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+
+	if err := ServeIO(os.Stdin, stdout, f); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// ServeIO serves the provider created by the factory, reading the requests from r and writing the responses to w,
+// until r reaches EOF. Requests other than init are served concurrently.
+func ServeIO(r io.Reader, w io.Writer, f Factory) error {
+	s := &server{factory: f, enc: json.NewEncoder(w)}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	dec := json.NewDecoder(r)
+	for {
+		var req Request
+		if err := dec.Decode(&req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading the request: %w", err)
+		}
+
+		if req.Method == MethodInit {
+			res, err := s.init(req.Params)
+			if err := s.respond(req.ID, res, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := s.handle(req)
+			s.respond(req.ID, res, err)
+		}()
+	}
+}
+
+type server struct {
+	factory Factory
+
+	writeMu sync.Mutex
+	enc     *json.Encoder
+
+	mu       sync.RWMutex
+	provider api.Provider
+}
+
+func (s *server) init(params json.RawMessage) (interface{}, error) {
+	var p InitParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	if p.ProtocolVersion != ProtocolVersion {
+		return nil, fmt.Errorf("unsupported protocol version %d: expected %d", p.ProtocolVersion, ProtocolVersion)
+	}
+
+	envFallback := func(k string) string {
+		return os.Getenv(EnvFallbackPrefix + strings.ToUpper(k))
+	}
+	provider, err := s.factory(config.MapConfig{M: p.Config, FallbackFunc: envFallback})
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.provider = provider
+	s.mu.Unlock()
+
+	return InitResult{ProtocolVersion: ProtocolVersion}, nil
+}
+
+func (s *server) handle(req Request) (interface{}, error) {
+	s.mu.RLock()
+	provider := s.provider
+	s.mu.RUnlock()
+
+	if provider == nil {
+		return nil, errors.New("the plugin isn't initialized")
+	}
+
+	var p GetParams
+	if err := json.Unmarshal(req.Params, &p); err != nil {
+		return nil, err
+	}
+
+	switch req.Method {
+	case MethodGetString:
+		v, err := api.GetString(context.Background(), provider, p.Key)
+		if err != nil {
+			return nil, err
+		}
+		return GetStringResult{Value: v}, nil
+	case MethodGetStringMap:
+		v, err := api.GetStringMap(context.Background(), provider, p.Key)
+		if err != nil {
+			return nil, err
+		}
+		return GetStringMapResult{Value: v}, nil
+	default:
+		return nil, fmt.Errorf("unsupported method %q", req.Method)
+	}
+}
+
+func (s *server) respond(id uint64, result interface{}, err error) error {
+	res := Response{ID: id}
+
+	if err == nil {
+		res.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		res.Result = nil
+		res.Error = &Error{Message: err.Error(), NotFound: errors.Is(err, api.ErrNotFound)}
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.enc.Encode(res); err != nil {
+		return fmt.Errorf("writing the response: %w", err)
+	}
+	return nil
+}
@@ -1,9 +1,11 @@
 package awskms
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"os"
+	"sync"
 
 	"gopkg.in/yaml.v3"
 
@@ -15,6 +17,8 @@ import (
 type provider struct {
 	// Keeping track of KMS services since we need a service per region
 	client *kms.KMS
+	// mu guards the lazy initialization of client, as the provider is shared by concurrent lookups
+	mu sync.Mutex
 
 	// AWS KMS configuration
 	Region, Profile, KeyId, EncryptionAlgorithm, EncryptionContext string
@@ -31,6 +35,10 @@ func New(cfg api.StaticConfig) *provider {
 }
 
 func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
 	cli := p.getClient()
 
 	blob, err := base64.URLEncoding.DecodeString(key)
@@ -60,7 +68,7 @@ func (p *provider) GetString(key string) (string, error) {
 		in = in.SetEncryptionContext(m)
 	}
 
-	result, err := cli.Decrypt(in)
+	result, err := cli.DecryptWithContext(ctx, in)
 	if err != nil {
 		return "", err
 	}
@@ -69,7 +77,11 @@ func (p *provider) GetString(key string) (string, error) {
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
-	yamlData, err := p.GetString(key)
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	yamlData, err := p.GetStringContext(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -84,6 +96,9 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 }
 
 func (p *provider) getClient() *kms.KMS {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if p.client != nil {
 		return p.client
 	}
@@ -1,22 +1,28 @@
 package awssecrets
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/kroonprins/vals/pkg/api"
 	"github.com/kroonprins/vals/pkg/awsclicompat"
 	"gopkg.in/yaml.v3"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 )
 
 type provider struct {
 	// Keeping track of secretsmanager services since we need a service per region
 	client *secretsmanager.SecretsManager
+	// mu guards the lazy initialization of client, as the provider is shared by concurrent lookups
+	mu sync.Mutex
 
 	// AWS SecretsManager global configuration
 	Region, VersionStage, VersionId, Profile string
@@ -35,6 +41,10 @@ func New(cfg api.StaticConfig) *provider {
 
 // Get gets an AWS SSM Parameter Store value
 func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
 	cli := p.getClient()
 
 	in := &secretsmanager.GetSecretValueInput{
@@ -49,8 +59,12 @@ func (p *provider) GetString(key string) (string, error) {
 		in = in.SetVersionId(p.VersionId)
 	}
 
-	out, err := cli.GetSecretValue(in)
+	out, err := cli.GetSecretValueWithContext(ctx, in)
 	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+			return "", api.NotFound(fmt.Errorf("get parameter: %v", err))
+		}
 		return "", fmt.Errorf("get parameter: %v", err)
 	}
 
@@ -69,8 +83,11 @@ func (p *provider) GetString(key string) (string, error) {
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
+	return p.GetStringMapContext(context.Background(), key)
+}
 
-	yamlStr, err := p.GetString(key)
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	yamlStr, err := p.GetStringContext(ctx, key)
 	if err == nil {
 		m := map[string]interface{}{}
 		if err := yaml.Unmarshal([]byte(yamlStr), &m); err != nil {
@@ -83,7 +100,7 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 
 	metaKey := strings.TrimRight(key, "/") + "/meta"
 
-	str, err := p.GetString(metaKey)
+	str, err := p.GetStringContext(ctx, metaKey)
 	if err != nil {
 		return nil, err
 	}
@@ -119,7 +136,7 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 	for _, suf := range suffixes {
 		sufKey := strings.TrimLeft(suf, "/")
 		full := strings.TrimRight(key, "/") + "/" + sufKey
-		str, err := p.GetString(full)
+		str, err := p.GetStringContext(ctx, full)
 		if err != nil {
 			return nil, err
 		}
@@ -131,11 +148,58 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 	return res, nil
 }
 
+// SetString puts the value as the new version of the secret, creating the secret if it doesn't exist
+func (p *provider) SetString(key string, value string) error {
+	return p.SetStringContext(context.Background(), key, value)
+}
+
+func (p *provider) SetStringContext(ctx context.Context, key string, value string) error {
+	cli := p.getClient()
+
+	_, err := cli.PutSecretValueWithContext(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(key),
+		SecretString: aws.String(value),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if !errors.As(err, &aerr) || aerr.Code() != secretsmanager.ErrCodeResourceNotFoundException {
+			return fmt.Errorf("awssecrets: put secret value: %v", err)
+		}
+
+		if _, err := cli.CreateSecretWithContext(ctx, &secretsmanager.CreateSecretInput{
+			Name:         aws.String(key),
+			SecretString: aws.String(value),
+		}); err != nil {
+			return fmt.Errorf("awssecrets: create secret: %v", err)
+		}
+	}
+
+	p.debugf("awssecrets: successfully stored key=%s", key)
+
+	return nil
+}
+
+// SetStringMap puts the map encoded in JSON as the new version of the secret, which GetStringMap reads back
+func (p *provider) SetStringMap(key string, m map[string]interface{}) error {
+	return p.SetStringMapContext(context.Background(), key, m)
+}
+
+func (p *provider) SetStringMapContext(ctx context.Context, key string, m map[string]interface{}) error {
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("awssecrets: encoding secret for key %q as json: %v", key, err)
+	}
+	return p.SetStringContext(ctx, key, string(bs))
+}
+
 func (p *provider) debugf(msg string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, msg+"\n", args...)
 }
 
 func (p *provider) getClient() *secretsmanager.SecretsManager {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if p.client != nil {
 		return p.client
 	}
@@ -2,8 +2,12 @@ package azurekeyvault
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azsecrets"
@@ -16,6 +20,8 @@ import (
 type provider struct {
 	// azure key vault client
 	clients map[string]*azsecrets.Client
+	// mu guards clients, as the provider is shared by concurrent lookups
+	mu sync.Mutex
 }
 
 func New(cfg api.StaticConfig) *provider {
@@ -25,6 +31,10 @@ func New(cfg api.StaticConfig) *provider {
 }
 
 func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
 	spec, err := parseKey(key)
 	if err != nil {
 		return "", err
@@ -38,21 +48,29 @@ func (p *provider) GetString(key string) (string, error) {
 		return "", err
 	}
 
-	secretBundle, err := client.GetSecret(context.Background(), spec.secretName, spec.secretVersion, nil)
+	secretBundle, err := client.GetSecret(ctx, spec.secretName, spec.secretVersion, nil)
 	if err != nil {
+		var rerr *azcore.ResponseError
+		if errors.As(err, &rerr) && rerr.StatusCode == http.StatusNotFound {
+			return "", api.NotFound(err)
+		}
 		return "", err
 	}
 	return *secretBundle.Value, err
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
 	spec, err := parseKey(key)
 	if err != nil {
 		return nil, err
 	}
 	if spec.secretName != "" {
 		m := map[string]interface{}{}
-		yamlStr, err := p.GetString(key)
+		yamlStr, err := p.GetStringContext(ctx, key)
 		if err != nil {
 			return nil, err
 		}
@@ -72,7 +90,7 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 		pager := client.NewListSecretsPager(&azsecrets.ListSecretsOptions{})
 
 		for pager.More() {
-			page, err := pager.NextPage(context.Background())
+			page, err := pager.NextPage(ctx)
 			if err != nil {
 				return nil, fmt.Errorf("failed to retrieve secrets from vault '%s': %v", spec.vaultBaseURL, err)
 			}
@@ -80,7 +98,7 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 				if secret.Managed != nil && *secret.Managed {
 					continue
 				}
-				secretVal, err := p.GetString(fmt.Sprintf("%s/%s", key, secret.ID.Name()))
+				secretVal, err := p.GetStringContext(ctx, fmt.Sprintf("%s/%s", key, secret.ID.Name()))
 				if err != nil {
 					return nil, err
 				}
@@ -92,7 +110,75 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 	}
 }
 
+// SetString sets the value as the new version of the secret
+func (p *provider) SetString(key string, value string) error {
+	return p.SetStringContext(context.Background(), key, value)
+}
+
+func (p *provider) SetStringContext(ctx context.Context, key string, value string) error {
+	spec, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(spec.secretName) == "" {
+		return fmt.Errorf("missing secret name: %q", key)
+	}
+	if spec.secretVersion != "" {
+		return fmt.Errorf("secret version can't be specified when setting secret: %q", key)
+	}
+
+	client, err := p.getClientForKeyVault(spec.vaultBaseURL)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.SetSecret(ctx, spec.secretName, azsecrets.SetSecretParameters{Value: &value}, nil)
+	return err
+}
+
+// SetStringMap sets the map encoded in JSON as the new version of the secret.
+// When the key has no secret name, each entry of the map is set as a secret in the key vault instead,
+// like GetStringMap returns all the secrets of the key vault.
+func (p *provider) SetStringMap(key string, m map[string]interface{}) error {
+	return p.SetStringMapContext(context.Background(), key, m)
+}
+
+func (p *provider) SetStringMapContext(ctx context.Context, key string, m map[string]interface{}) error {
+	spec, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+
+	if spec.secretName != "" {
+		bs, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("error while encoding secret for key %q as json: %v", key, err)
+		}
+		return p.SetStringContext(ctx, key, string(bs))
+	}
+
+	for name, v := range m {
+		switch v.(type) {
+		case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+			return fmt.Errorf("unexpected type of value for secret %q: %T", name, v)
+		}
+		if err := p.SetStringContext(ctx, fmt.Sprintf("%s/%s", strings.TrimSuffix(key, "/"), name), fmt.Sprintf("%v", v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsPathPerKey returns true for the keys without secret names, for which GetStringMap returns all the secrets of the key vault
+func (p *provider) IsPathPerKey(key string) bool {
+	spec, err := parseKey(key)
+	return err == nil && spec.secretName == ""
+}
+
 func (p *provider) getClientForKeyVault(vaultBaseURL string) (*azsecrets.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if val, ok := p.clients[vaultBaseURL]; val != nil || ok {
 		return p.clients[vaultBaseURL], nil
 	}
@@ -1,6 +1,7 @@
 package echo
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -16,10 +17,25 @@ func New(cfg api.StaticConfig) *provider {
 }
 
 func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	return strings.TrimRight(key, "/"), nil
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	keys := strings.Split(key, "/")
 
 	res := map[string]interface{}{}
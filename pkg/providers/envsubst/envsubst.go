@@ -1,6 +1,7 @@
 package envsubst
 
 import (
+	"context"
 	"strings"
 
 	envSubst "github.com/a8m/envsubst"
@@ -17,6 +18,14 @@ func New(cfg api.StaticConfig) *provider {
 }
 
 func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
 	key = strings.TrimSuffix(key, "/")
 	key = strings.TrimSpace(key)
 
@@ -28,6 +37,14 @@ func (p *provider) GetString(key string) (string, error) {
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	key = strings.TrimSuffix(key, "/")
 	key = strings.TrimSpace(key)
 
@@ -0,0 +1,195 @@
+// Package exec implements the provider that gets values from the STDOUT of commands,
+// like `aws ecr get-login-password` or `op read`.
+//
+// Refs only refer to commands by name, like `ref+exec://ecr-password`, and the commands themselves are configured
+// out of the documents, either with Register or in the YAML file at $VALS_EXEC_COMMANDS_FILE:
+//
+//	ecr-password:
+//	  argv: [aws, ecr, get-login-password, --region, us-east-1]
+//	  timeout: 10s
+//
+// so that documents can't run arbitrary commands.
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kroonprins/vals/pkg/api"
+)
+
+const (
+	// EnvCommandsFile is the environment variable of the path to the YAML file of the allowed commands
+	EnvCommandsFile = "VALS_EXEC_COMMANDS_FILE"
+
+	// DefaultTimeout is how long commands may run when their Timeout is zero
+	DefaultTimeout = 30 * time.Second
+
+	// maxStderr is the maximum number of bytes of the STDERR of failed commands included in errors
+	maxStderr = 1024
+)
+
+// Command is a command that refs may run
+type Command struct {
+	// Argv is the path or the name on PATH of the executable, followed by the arguments
+	Argv []string `yaml:"argv"`
+	// Timeout is how long the command may run. Defaults to DefaultTimeout when zero.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+var (
+	mu       sync.RWMutex
+	commands = map[string]Command{}
+)
+
+// Register allows refs to run the command as `ref+exec://<name>`.
+// It panics if the command has no argv or a command is already registered with the name.
+func Register(name string, cmd Command) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(cmd.Argv) == 0 {
+		panic(fmt.Sprintf("exec: command %q has no argv", name))
+	}
+	if _, dup := commands[name]; dup {
+		panic(fmt.Sprintf("exec: command %q is already registered", name))
+	}
+	commands[name] = cmd
+}
+
+type provider struct {
+	commands map[string]Command
+	err      error
+}
+
+// New returns the provider of the registered commands and the commands in the file at $VALS_EXEC_COMMANDS_FILE.
+// The config is ignored, as refs must not be able to change the commands.
+func New(cfg api.StaticConfig) *provider {
+	p := &provider{commands: map[string]Command{}}
+
+	if f := os.Getenv(EnvCommandsFile); f != "" {
+		m, err := readCommands(f)
+		if err != nil {
+			p.err = err
+			return p
+		}
+		p.commands = m
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	for name, cmd := range commands {
+		p.commands[name] = cmd
+	}
+
+	return p
+}
+
+func readCommands(f string) (map[string]Command, error) {
+	bs, err := os.ReadFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", EnvCommandsFile, err)
+	}
+
+	m := map[string]Command{}
+	if err := yaml.Unmarshal(bs, &m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", f, err)
+	}
+	for name, cmd := range m {
+		if len(cmd.Argv) == 0 {
+			return nil, fmt.Errorf("%s: command %q has no argv", f, name)
+		}
+	}
+	return m, nil
+}
+
+func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+// GetStringContext returns the STDOUT of the command, without the trailing newlines
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
+	bs, err := p.run(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(bs), "\r\n"), nil
+}
+
+func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+// GetStringMapContext returns the STDOUT of the command parsed as YAML or JSON
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	bs, err := p.run(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal(bs, &m); err != nil {
+		return nil, fmt.Errorf("command %q: parsing the output: %w", strings.TrimSuffix(key, "/"), err)
+	}
+	return m, nil
+}
+
+func (p *provider) run(ctx context.Context, key string) ([]byte, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	name := strings.TrimSuffix(key, "/")
+	cmd, ok := p.commands[name]
+	if !ok {
+		allowed := make([]string, 0, len(p.commands))
+		for n := range p.commands {
+			allowed = append(allowed, n)
+		}
+		sort.Strings(allowed)
+		return nil, fmt.Errorf("command %q is not allowed: register it or add it to $%s. Allowed commands: %s",
+			name, EnvCommandsFile, strings.Join(allowed, ", "))
+	}
+
+	timeout := cmd.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	c := osexec.CommandContext(ctx, cmd.Argv[0], cmd.Argv[1:]...)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+
+	if err := c.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if errors.Is(ctxErr, context.DeadlineExceeded) {
+				return nil, fmt.Errorf("command %q: timed out: %w", name, ctxErr)
+			}
+			return nil, fmt.Errorf("command %q: %w", name, ctxErr)
+		}
+
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > maxStderr {
+			msg = msg[:maxStderr] + "..."
+		}
+		if msg != "" {
+			return nil, fmt.Errorf("command %q: %w: %s", name, err, msg)
+		}
+		return nil, fmt.Errorf("command %q: %w", name, err)
+	}
+
+	return stdout.Bytes(), nil
+}
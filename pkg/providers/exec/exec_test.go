@@ -0,0 +1,88 @@
+package exec
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kroonprins/vals/pkg/config"
+)
+
+func TestProvider(t *testing.T) {
+	Register("test-string", Command{Argv: []string{"sh", "-c", "echo s3cr3t"}})
+	Register("test-map", Command{Argv: []string{"sh", "-c", `echo '{"user": "admin", "password": "s3cr3t"}'`}})
+	Register("test-fail", Command{Argv: []string{"sh", "-c", "echo denied >&2; exit 3"}})
+	Register("test-slow", Command{Argv: []string{"sleep", "10"}, Timeout: 100 * time.Millisecond})
+
+	p := New(config.Map(map[string]interface{}{}))
+	ctx := context.Background()
+
+	s, err := p.GetStringContext(ctx, "test-string")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s != "s3cr3t" {
+		t.Errorf("unexpected value: expected=%q, got=%q", "s3cr3t", s)
+	}
+
+	m, err := p.GetStringMapContext(ctx, "test-map")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := map[string]interface{}{"user": "admin", "password": "s3cr3t"}; !reflect.DeepEqual(m, expected) {
+		t.Errorf("unexpected map: expected=%v, got=%v", expected, m)
+	}
+
+	if _, err := p.GetStringContext(ctx, "test-fail"); err == nil || err.Error() != `command "test-fail": exit status 3: denied` {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := p.GetStringContext(ctx, "test-slow"); err == nil || !strings.Contains(err.Error(), `command "test-slow": timed out`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected the command to be killed after the timeout, took %s", d)
+	}
+
+	if _, err := p.GetStringContext(ctx, "sh"); err == nil || !strings.HasPrefix(err.Error(), `command "sh" is not allowed`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestProvider_CommandsFile(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "commands.yaml")
+	if err := os.WriteFile(f, []byte(`token:
+  argv: [sh, -c, "printf 'abc\n\n'"]
+  timeout: 5s
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvCommandsFile, f)
+
+	p := New(config.Map(map[string]interface{}{}))
+
+	if got := p.commands["token"].Timeout; got != 5*time.Second {
+		t.Errorf("unexpected timeout: expected=%s, got=%s", 5*time.Second, got)
+	}
+
+	s, err := p.GetString("token")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s != "abc" {
+		t.Errorf("unexpected value: expected=%q, got=%q", "abc", s)
+	}
+
+	if err := os.WriteFile(f, []byte("token:\n  timeout: 5s\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p = New(config.Map(map[string]interface{}{}))
+	if _, err := p.GetString("token"); err == nil || !strings.Contains(err.Error(), `command "token" has no argv`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -1,10 +1,17 @@
 package file
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
 	"io/ioutil"
+	"path/filepath"
 	"strings"
 
 	"github.com/kroonprins/vals/pkg/api"
+	"github.com/kroonprins/vals/pkg/transforms"
 	"gopkg.in/yaml.v3"
 )
 
@@ -17,8 +24,16 @@ func New(cfg api.StaticConfig) *provider {
 }
 
 func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
 	key = strings.TrimSuffix(key, "/")
-	bs, err := ioutil.ReadFile(key)
+	bs, err := readFile(key)
 	if err != nil {
 		return "", err
 	}
@@ -26,8 +41,16 @@ func (p *provider) GetString(key string) (string, error) {
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	key = strings.TrimSuffix(key, "/")
-	bs, err := ioutil.ReadFile(key)
+	bs, err := readFile(key)
 	if err != nil {
 		return nil, err
 	}
@@ -38,3 +61,65 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 	}
 	return m, nil
 }
+
+// SetString writes the value to the file. New files are created only readable by the owner, as they may contain secrets.
+func (p *provider) SetString(key string, value string) error {
+	return p.SetStringContext(context.Background(), key, value)
+}
+
+func (p *provider) SetStringContext(ctx context.Context, key string, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	key = strings.TrimSuffix(key, "/")
+	return ioutil.WriteFile(key, []byte(value), 0600)
+}
+
+// SetStringMap writes the map to the file in the format of the file, which is JSON for the files named `*.json`
+// or already containing JSON, and YAML otherwise
+func (p *provider) SetStringMap(key string, m map[string]interface{}) error {
+	return p.SetStringMapContext(context.Background(), key, m)
+}
+
+func (p *provider) SetStringMapContext(ctx context.Context, key string, m map[string]interface{}) error {
+	if isJSON(strings.TrimSuffix(key, "/")) {
+		bs, err := json.MarshalIndent(transforms.ToJSONCompatible(m), "", "  ")
+		if err != nil {
+			return err
+		}
+		return p.SetStringContext(ctx, key, string(bs)+"\n")
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return p.SetStringContext(ctx, key, buf.String())
+}
+
+// isJSON returns true when the file is named `*.json`, or exists and contains a JSON object
+func isJSON(path string) bool {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return true
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	bs = bytes.TrimSpace(bs)
+	return len(bs) > 0 && bs[0] == '{' && json.Valid(bs)
+}
+
+func readFile(path string) ([]byte, error) {
+	bs, err := ioutil.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, api.NotFound(err)
+	}
+	return bs, err
+}
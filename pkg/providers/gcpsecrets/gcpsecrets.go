@@ -2,6 +2,7 @@ package gcpsecrets
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strconv"
@@ -10,6 +11,8 @@ import (
 	sm "cloud.google.com/go/secretmanager/apiv1"
 	"github.com/kroonprins/vals/pkg/api"
 	smpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"gopkg.in/yaml.v3"
 )
 
@@ -34,9 +37,7 @@ func New(cfg api.StaticConfig) *provider {
 	if version == "" {
 		version = "latest"
 	}
-	return &provider{
-		version: version,
-	}
+	p.version = version
 
 	optional := cfg.String("optional")
 	if optional != "" {
@@ -55,7 +56,11 @@ func New(cfg api.StaticConfig) *provider {
 }
 
 func (p *provider) GetString(key string) (string, error) {
-	secret, err := p.getSecret(context.TODO(), key)
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
+	secret, err := p.getSecret(ctx, key)
 	if err != nil {
 		return "", err
 	}
@@ -63,7 +68,11 @@ func (p *provider) GetString(key string) (string, error) {
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
-	secret, err := p.getSecret(context.TODO(), key)
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	secret, err := p.getSecret(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -74,6 +83,58 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 	return secretMap, nil
 }
 
+// SetString adds the value as the new version of the secret, creating the secret with the automatic replication
+// if it doesn't exist
+func (p *provider) SetString(key string, value string) error {
+	return p.SetStringContext(context.Background(), key, value)
+}
+
+func (p *provider) SetStringContext(ctx context.Context, key string, value string) error {
+	c, err := sm.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer c.Close()
+
+	project, name, _ := strings.Cut(key, "/")
+	parent := fmt.Sprintf("projects/%s/secrets/%s", project, name)
+	payload := &smpb.SecretPayload{Data: []byte(value)}
+
+	_, err = c.AddSecretVersion(ctx, &smpb.AddSecretVersionRequest{Parent: parent, Payload: payload})
+	if status.Code(err) == codes.NotFound {
+		_, err = c.CreateSecret(ctx, &smpb.CreateSecretRequest{
+			Parent:   fmt.Sprintf("projects/%s", project),
+			SecretId: name,
+			Secret: &smpb.Secret{
+				Replication: &smpb.Replication{
+					Replication: &smpb.Replication_Automatic_{Automatic: &smpb.Replication_Automatic{}},
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create secret: %w", err)
+		}
+		_, err = c.AddSecretVersion(ctx, &smpb.AddSecretVersionRequest{Parent: parent, Payload: payload})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add secret version: %w", err)
+	}
+	return nil
+}
+
+// SetStringMap adds the map encoded in JSON as the new version of the secret, which GetStringMap reads back
+func (p *provider) SetStringMap(key string, m map[string]interface{}) error {
+	return p.SetStringMapContext(context.Background(), key, m)
+}
+
+func (p *provider) SetStringMapContext(ctx context.Context, key string, m map[string]interface{}) error {
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal secret: %w", err)
+	}
+	return p.SetStringContext(ctx, key, string(bs))
+}
+
 func (p *provider) getSecret(ctx context.Context, key string) ([]byte, error) {
 	c, err := sm.NewClient(ctx)
 	if err != nil {
@@ -93,6 +154,9 @@ func (p *provider) getSecret(ctx context.Context, key string) ([]byte, error) {
 			return []byte(*p.fallback), nil
 		}
 
+		if status.Code(err) == codes.NotFound {
+			return nil, api.NotFound(fmt.Errorf("failed to get secret: %w", err))
+		}
 		return nil, fmt.Errorf("failed to get secret: %w", err)
 	}
 	return secret.GetPayload().GetData(), nil
@@ -2,6 +2,7 @@ package gcs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"strconv"
@@ -17,9 +18,6 @@ import (
 
 type provider struct {
 	Generation string
-
-	client *storage.Client
-	ctx    context.Context
 }
 
 // New creates a new GCS provider
@@ -32,6 +30,10 @@ func New(cfg api.StaticConfig) *provider {
 
 // Get secret string from GCS
 func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
 	var client *storage.Client
 	var err error
 	var generation int64
@@ -47,22 +49,22 @@ func (p *provider) GetString(key string) (string, error) {
 		generation = g
 	}
 
-	ctx := context.Background()
+	// Keep the historical 10s timeout unless the caller has set its own deadline
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Second*10)
+		defer cancel()
+	}
+
 	client, err = storage.NewClient(ctx)
 	if err != nil {
 		return "", fmt.Errorf("storage.NewClient: %v", err)
 	}
 	defer client.Close()
 
-	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
-	defer cancel()
-
-	p.client = client
-	p.ctx = ctx
-
 	var rc *storage.Reader
 	if generation > 0 {
-		ok, err := p.isVersioningEnabled(bucket)
+		ok, err := p.isVersioningEnabled(ctx, client, bucket)
 		if err != nil {
 			return "", fmt.Errorf("bucket %s: %v", bucket, err)
 		}
@@ -71,12 +73,16 @@ func (p *provider) GetString(key string) (string, error) {
 		}
 
 		rc, err = client.Bucket(bucket).Object(objKey).Generation(generation).NewReader(ctx)
-		if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return "", api.NotFound(fmt.Errorf("bucket %s with generation %s: %v", bucket, p.Generation, err))
+		} else if err != nil {
 			return "", fmt.Errorf("bucket %s with generation %s: %v", bucket, p.Generation, err)
 		}
 	} else {
 		rc, err = client.Bucket(bucket).Object(objKey).NewReader(ctx)
-		if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return "", api.NotFound(fmt.Errorf("bucket %s: %v", bucket, err))
+		} else if err != nil {
 			return "", fmt.Errorf("bucket %s: %v", bucket, err)
 		}
 	}
@@ -93,7 +99,11 @@ func (p *provider) GetString(key string) (string, error) {
 
 // Convert yaml to map interface and return the requested keys
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
-	yamlData, err := p.GetString(key)
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	yamlData, err := p.GetStringContext(ctx, key)
 	if err != nil {
 		fmt.Println(err)
 		return nil, err
@@ -109,8 +119,8 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 }
 
 // Check is versioning is enabled in the bucket
-func (p *provider) isVersioningEnabled(bucketName string) (bool, error) {
-	attrs, err := p.client.Bucket(bucketName).Attrs(p.ctx)
+func (p *provider) isVersioningEnabled(ctx context.Context, client *storage.Client, bucketName string) (bool, error) {
+	attrs, err := client.Bucket(bucketName).Attrs(ctx)
 	if err != nil {
 		return false, fmt.Errorf("Bucket(%q).Attrs: %v", bucketName, err)
 	}
@@ -1,6 +1,7 @@
 package gitlab
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -52,6 +53,10 @@ func New(cfg api.StaticConfig) *provider {
 
 // Get gets secret from GitLab API
 func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
 	splits := strings.Split(key, "/")
 	gitlabToken, ok := os.LookupEnv("GITLAB_TOKEN")
 	if !ok {
@@ -69,7 +74,7 @@ func (p *provider) GetString(key string) (string, error) {
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: p.SSLVerify},
 	}
 	client := &http.Client{Transport: tr}
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", err
 	}
@@ -84,6 +89,10 @@ func (p *provider) GetString(key string) (string, error) {
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotFound {
+		return "", api.NotFound(fmt.Errorf("gitlab: variable %s not found in project %s", splits[2], splits[1]))
+	}
+
 	var g gitlabSecret
 	err = json.NewDecoder(res.Body).Decode(&g)
 	if err != nil {
@@ -94,10 +103,13 @@ func (p *provider) GetString(key string) (string, error) {
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
+	return p.GetStringMapContext(context.Background(), key)
+}
 
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
 	secretMap := map[string]interface{}{}
 
-	secretString, err := p.GetString(key)
+	secretString, err := p.GetStringContext(ctx, key)
 	if err != nil {
 		return nil, err
 	}
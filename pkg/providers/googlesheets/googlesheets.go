@@ -28,8 +28,12 @@ func New(cfg api.StaticConfig) *provider {
 }
 
 func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
 	splits := strings.Split(key, "/")
-	kvs, err := p.GetStringMap(splits[0])
+	kvs, err := p.GetStringMapContext(ctx, splits[0])
 	if err != nil {
 		return "", err
 	}
@@ -38,7 +42,11 @@ func (p *provider) GetString(key string) (string, error) {
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
-	return FetchKVsWithCredentials(context.Background(), p.credentialsFile, key)
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	return FetchKVsWithCredentials(ctx, p.credentialsFile, key)
 }
 
 // getClient returns the authenticated HTTP client by retrieving a token, saving the token,
@@ -153,7 +161,7 @@ func FetchKVs(ctx context.Context, client *http.Client, spreadsheetId string) (m
 	}
 
 	readRange := "A1:B"
-	resp, err := srv.Spreadsheets.Values.Get(spreadsheetId, readRange).Do()
+	resp, err := srv.Spreadsheets.Values.Get(spreadsheetId, readRange).Context(ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("unable to get values from sheet: %v", err)
 	}
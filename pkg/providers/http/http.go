@@ -0,0 +1,303 @@
+// Package http implements the providers for the `http` and `https` schemes, which GET the documents at URLs,
+// like `ref+https://config.example.com/app.json#/db/host`.
+//
+// Refs may send the environment variables as credentials only to the hosts allowed out of the documents,
+// in the YAML file at $VALS_HTTP_CREDENTIALS_FILE:
+//
+//	CONFIG_TOKEN: [config.example.com]
+//
+// so that documents can't send the credentials elsewhere.
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kroonprins/vals/pkg/api"
+)
+
+const (
+	// EnvCredentialsFile is the environment variable of the path to the YAML file of the environment variables
+	// that refs may send as credentials, by the hosts they may be sent to
+	EnvCredentialsFile = "VALS_HTTP_CREDENTIALS_FILE"
+
+	// DefaultTimeout is how long requests may take, unless the context of the lookup ends earlier
+	DefaultTimeout = 30 * time.Second
+
+	paramHeaderEnv    = "header_env"
+	paramBasicAuthEnv = "basic_auth_env"
+	paramCAFile       = "ca_file"
+)
+
+type provider struct {
+	scheme string
+	// query is the query parameters of the ref other than the ones configuring the provider, which are sent to the server
+	query url.Values
+
+	headerName   string
+	headerEnv    string
+	basicAuthEnv string
+	caFile       string
+
+	// credentials is the hosts that each environment variable may be sent to, read from $VALS_HTTP_CREDENTIALS_FILE
+	credentials    map[string][]string
+	credentialsErr error
+
+	// mu guards the lazy initialization of client and the responses cached for their ETags,
+	// as the provider is shared by concurrent lookups
+	mu        sync.Mutex
+	client    *nethttp.Client
+	clientErr error
+	etags     map[string]cachedResponse
+}
+
+type cachedResponse struct {
+	etag string
+	body []byte
+}
+
+// New returns the provider for the scheme, either `http` or `https`.
+//
+// `header_env=ENV` sends the value of the environment variable as the `Authorization` header,
+// and `header_env=NAME:ENV` as the header of the name.
+// `basic_auth_env=ENV` sends the value of the environment variable, formatted as `user:password`, with basic authentication.
+// Both are sent only over https, to the hosts allowed in the file at $VALS_HTTP_CREDENTIALS_FILE,
+// and are dropped when the server redirects elsewhere.
+// `ca_file=PATH` verifies the server with the PEM-encoded CA certificates in the file, in addition to the ones of the system.
+// The other query parameters are sent to the server.
+func New(cfg api.StaticConfig, scheme string) *provider {
+	p := &provider{
+		scheme:      scheme,
+		query:       url.Values{},
+		etags:       map[string]cachedResponse{},
+		credentials: map[string][]string{},
+	}
+
+	if f := os.Getenv(EnvCredentialsFile); f != "" {
+		p.credentials, p.credentialsErr = readCredentials(f)
+	}
+
+	if h := cfg.String(paramHeaderEnv); h != "" {
+		p.headerName, p.headerEnv = "Authorization", h
+		if i := strings.Index(h, ":"); i >= 0 {
+			p.headerName, p.headerEnv = h[:i], h[i+1:]
+		}
+	}
+	p.basicAuthEnv = cfg.String(paramBasicAuthEnv)
+	p.caFile = cfg.String(paramCAFile)
+
+	for k, v := range cfg.Map() {
+		switch k {
+		case paramHeaderEnv, paramBasicAuthEnv, paramCAFile:
+		default:
+			p.query.Set(k, fmt.Sprintf("%v", v))
+		}
+	}
+
+	return p
+}
+
+func readCredentials(f string) (map[string][]string, error) {
+	bs, err := ioutil.ReadFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", EnvCredentialsFile, err)
+	}
+
+	m := map[string][]string{}
+	if err := yaml.Unmarshal(bs, &m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", f, err)
+	}
+	return m, nil
+}
+
+// credential returns the value of the environment variable, if it may be sent to the URL
+func (p *provider) credential(u *url.URL, env string) (string, error) {
+	if u.Scheme != "https" {
+		return "", fmt.Errorf("the environment variable %s is sent only over https", env)
+	}
+	if p.credentialsErr != nil {
+		return "", p.credentialsErr
+	}
+
+	allowed := false
+	for _, host := range p.credentials[env] {
+		if host == u.Host || host == u.Hostname() {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", fmt.Errorf("the environment variable %s is not allowed to be sent to %s: add the host to it in $%s", env, u.Host, EnvCredentialsFile)
+	}
+
+	v, ok := os.LookupEnv(env)
+	if !ok {
+		return "", fmt.Errorf("the environment variable %s is not set", env)
+	}
+	return v, nil
+}
+
+func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+// GetStringContext returns the body of the response to the GET request
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
+	bs, err := p.get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+// GetStringMapContext returns the body of the response to the GET request parsed as YAML or JSON
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	bs, err := p.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal(bs, &m); err != nil {
+		return nil, fmt.Errorf("parsing the body of %s: %w", p.url(key), err)
+	}
+	return m, nil
+}
+
+// url returns the URL for the key made of the host and the path of the ref, like `example.com/app.json`
+func (p *provider) url(key string) string {
+	host, path, _ := strings.Cut(key, "/")
+	u := url.URL{
+		Scheme:   p.scheme,
+		Host:     host,
+		Path:     "/" + path,
+		RawQuery: p.query.Encode(),
+	}
+	return u.String()
+}
+
+func (p *provider) get(ctx context.Context, key string) ([]byte, error) {
+	client, err := p.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	u := p.url(key)
+	req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.headerEnv != "" {
+		v, err := p.credential(req.URL, p.headerEnv)
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: header %s: %w", u, p.headerName, err)
+		}
+		req.Header.Set(p.headerName, v)
+	}
+	if p.basicAuthEnv != "" {
+		v, err := p.credential(req.URL, p.basicAuthEnv)
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: basic authentication: %w", u, err)
+		}
+		user, password, _ := strings.Cut(v, ":")
+		req.SetBasicAuth(user, password)
+	}
+
+	p.mu.Lock()
+	cached, hasCached := p.etags[u]
+	p.mu.Unlock()
+	if hasCached {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == nethttp.StatusNotModified && hasCached:
+		return cached.body, nil
+	case res.StatusCode == nethttp.StatusNotFound || res.StatusCode == nethttp.StatusGone:
+		return nil, api.NotFound(fmt.Errorf("GET %s: %s", u, res.Status))
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return nil, fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: reading the body: %w", u, err)
+	}
+
+	if etag := res.Header.Get("ETag"); etag != "" {
+		p.mu.Lock()
+		p.etags[u] = cachedResponse{etag: etag, body: body}
+		p.mu.Unlock()
+	}
+
+	return body, nil
+}
+
+func (p *provider) getClient() (*nethttp.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client != nil || p.clientErr != nil {
+		return p.client, p.clientErr
+	}
+
+	transport := nethttp.DefaultTransport.(*nethttp.Transport).Clone()
+	if p.caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(p.caFile)
+		if err != nil {
+			p.clientErr = fmt.Errorf("reading %s: %w", paramCAFile, err)
+			return nil, p.clientErr
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			p.clientErr = fmt.Errorf("no certificate found in %s %s", paramCAFile, p.caFile)
+			return nil, p.clientErr
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	p.client = &nethttp.Client{Transport: transport, Timeout: DefaultTimeout, CheckRedirect: p.checkRedirect}
+	return p.client, nil
+}
+
+// checkRedirect drops the credentials from the redirects to other hosts or over http,
+// as net/http drops only the standard headers like Authorization, and only for other domains
+func (p *provider) checkRedirect(req *nethttp.Request, via []*nethttp.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+
+	if req.URL.Host != via[0].URL.Host || req.URL.Scheme != "https" {
+		req.Header.Del("Authorization")
+		if p.headerName != "" {
+			req.Header.Del(p.headerName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package http
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kroonprins/vals/pkg/api"
+	"github.com/kroonprins/vals/pkg/config"
+)
+
+func newTestServer(t *testing.T, tls bool) (*httptest.Server, *int32, *int32) {
+	t.Helper()
+
+	var requests, notModified int32
+
+	mux := nethttp.NewServeMux()
+	mux.HandleFunc("/app.json", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(nethttp.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `{"db": {"host": "db.example.com", "env": "`+r.URL.Query().Get("env")+`"}}`)
+	})
+	mux.HandleFunc("/private.yaml", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		user, password, ok := r.BasicAuth()
+		if r.Header.Get("X-Api-Key") != "s3cr3t" && (!ok || user != "alice" || password != "pa:ss") {
+			w.WriteHeader(nethttp.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "token: t0ken\n")
+	})
+
+	var ts *httptest.Server
+	if tls {
+		ts = httptest.NewTLSServer(mux)
+	} else {
+		ts = httptest.NewServer(mux)
+	}
+	t.Cleanup(ts.Close)
+
+	return ts, &requests, &notModified
+}
+
+func TestProvider(t *testing.T) {
+	ts, requests, notModified := newTestServer(t, false)
+	host := strings.TrimPrefix(ts.URL, "http://")
+	ctx := context.Background()
+
+	p := New(config.Map(map[string]interface{}{"env": "prod"}), "http")
+
+	s, err := p.GetStringContext(ctx, host+"/app.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `{"db": {"host": "db.example.com", "env": "prod"}}`; s != expected {
+		t.Errorf("unexpected value: expected=%q, got=%q", expected, s)
+	}
+
+	// The second request is revalidated with the ETag of the first response
+	m, err := p.GetStringMapContext(ctx, host+"/app.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := map[string]interface{}{"db": map[string]interface{}{"host": "db.example.com", "env": "prod"}}; !reflect.DeepEqual(m, expected) {
+		t.Errorf("unexpected map: expected=%v, got=%v", expected, m)
+	}
+	if got := atomic.LoadInt32(requests); got != 2 {
+		t.Errorf("unexpected number of requests: expected=2, got=%d", got)
+	}
+	if got := atomic.LoadInt32(notModified); got != 1 {
+		t.Errorf("unexpected number of responses not modified: expected=1, got=%d", got)
+	}
+
+	_, err = p.GetStringContext(ctx, host+"/missing.json")
+	if !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	_, err = p.GetStringContext(ctx, host+"/private.yaml")
+	if err == nil || err.Error() != "GET "+ts.URL+"/private.yaml?env=prod: 401 Unauthorized" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// writeCAFile writes the certificate of the TLS test server to a file for ca_file
+func writeCAFile(t *testing.T, ts *httptest.Server) string {
+	t.Helper()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return caFile
+}
+
+// allowCredentials writes the hosts that the environment variables may be sent to into $VALS_HTTP_CREDENTIALS_FILE
+func allowCredentials(t *testing.T, credentials string) {
+	t.Helper()
+
+	f := filepath.Join(t.TempDir(), "credentials.yaml")
+	if err := os.WriteFile(f, []byte(credentials), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvCredentialsFile, f)
+}
+
+func TestProvider_Auth(t *testing.T) {
+	ts, _, _ := newTestServer(t, true)
+	host := strings.TrimPrefix(ts.URL, "https://")
+	caFile := writeCAFile(t, ts)
+	ctx := context.Background()
+
+	t.Setenv("TEST_VALS_API_KEY", "s3cr3t")
+	t.Setenv("TEST_VALS_BASIC_AUTH", "alice:pa:ss")
+	allowCredentials(t, fmt.Sprintf(`TEST_VALS_API_KEY: [%s]
+TEST_VALS_BASIC_AUTH: [%s]
+TEST_VALS_UNSET: [%s]
+`, host, strings.Split(host, ":")[0], host))
+
+	for _, cfg := range []map[string]interface{}{
+		{"header_env": "X-Api-Key:TEST_VALS_API_KEY", "ca_file": caFile},
+		{"basic_auth_env": "TEST_VALS_BASIC_AUTH", "ca_file": caFile},
+	} {
+		p := New(config.Map(cfg), "https")
+
+		m, err := p.GetStringMapContext(ctx, host+"/private.yaml")
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", cfg, err)
+		}
+		if m["token"] != "t0ken" {
+			t.Errorf("unexpected map for %v: %v", cfg, m)
+		}
+	}
+
+	testcases := []struct {
+		name     string
+		scheme   string
+		cfg      map[string]interface{}
+		expected string
+	}{
+		{
+			name:     "unset",
+			scheme:   "https",
+			cfg:      map[string]interface{}{"header_env": "TEST_VALS_UNSET", "ca_file": caFile},
+			expected: "header Authorization: the environment variable TEST_VALS_UNSET is not set",
+		},
+		{
+			name:     "not allowed",
+			scheme:   "https",
+			cfg:      map[string]interface{}{"header_env": "HOME", "ca_file": caFile},
+			expected: "header Authorization: the environment variable HOME is not allowed to be sent to " + host,
+		},
+		{
+			name:     "over http",
+			scheme:   "http",
+			cfg:      map[string]interface{}{"basic_auth_env": "TEST_VALS_BASIC_AUTH"},
+			expected: "basic authentication: the environment variable TEST_VALS_BASIC_AUTH is sent only over https",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(config.Map(tc.cfg), tc.scheme).GetStringContext(ctx, host+"/private.yaml")
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("unexpected error: expected to contain %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestProvider_AuthRedirect(t *testing.T) {
+	var received nethttp.Header
+	elsewhere := httptest.NewTLSServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		received = r.Header.Clone()
+		fmt.Fprint(w, "foo: bar\n")
+	}))
+	t.Cleanup(elsewhere.Close)
+
+	ts := httptest.NewTLSServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		nethttp.Redirect(w, r, elsewhere.URL+"/app.yaml", nethttp.StatusFound)
+	}))
+	t.Cleanup(ts.Close)
+
+	host := strings.TrimPrefix(ts.URL, "https://")
+	caFile := writeCAFile(t, ts)
+
+	t.Setenv("TEST_VALS_API_KEY", "s3cr3t")
+	t.Setenv("TEST_VALS_TOKEN", "Bearer t0ken")
+	allowCredentials(t, fmt.Sprintf("TEST_VALS_API_KEY: [%s]\nTEST_VALS_TOKEN: [%s]\n", host, host))
+
+	for _, cfg := range []map[string]interface{}{
+		{"header_env": "X-Api-Key:TEST_VALS_API_KEY", "ca_file": caFile},
+		{"header_env": "TEST_VALS_TOKEN", "ca_file": caFile},
+	} {
+		received = nil
+
+		if _, err := New(config.Map(cfg), "https").GetStringMapContext(context.Background(), host+"/app.yaml"); err != nil {
+			t.Fatalf("unexpected error for %v: %v", cfg, err)
+		}
+		if received == nil {
+			t.Fatalf("the redirect for %v wasn't followed", cfg)
+		}
+		if v := received.Get("X-Api-Key") + received.Get("Authorization"); v != "" {
+			t.Errorf("the credentials for %v were sent to the other host: %q", cfg, v)
+		}
+	}
+}
+
+func TestProvider_CAFile(t *testing.T) {
+	ts, _, _ := newTestServer(t, true)
+	host := strings.TrimPrefix(ts.URL, "https://")
+	ctx := context.Background()
+
+	if _, err := New(config.Map(map[string]interface{}{}), "https").GetStringContext(ctx, host+"/app.json"); err == nil {
+		t.Errorf("expected the certificate of the test server not to be trusted without ca_file")
+	}
+
+	p := New(config.Map(map[string]interface{}{"ca_file": writeCAFile(t, ts)}), "https")
+	m, err := p.GetStringMapContext(ctx, host+"/app.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := m["db"]; !ok {
+		t.Errorf("unexpected map: %v", m)
+	}
+}
@@ -0,0 +1,141 @@
+package providers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kroonprins/vals/pkg/api"
+	"github.com/kroonprins/vals/pkg/plugin"
+	"github.com/kroonprins/vals/pkg/providers/awskms"
+	"github.com/kroonprins/vals/pkg/providers/awssecrets"
+	"github.com/kroonprins/vals/pkg/providers/azurekeyvault"
+	"github.com/kroonprins/vals/pkg/providers/echo"
+	"github.com/kroonprins/vals/pkg/providers/envsubst"
+	"github.com/kroonprins/vals/pkg/providers/exec"
+	"github.com/kroonprins/vals/pkg/providers/file"
+	"github.com/kroonprins/vals/pkg/providers/gcpsecrets"
+	"github.com/kroonprins/vals/pkg/providers/gcs"
+	"github.com/kroonprins/vals/pkg/providers/gitlab"
+	"github.com/kroonprins/vals/pkg/providers/googlesheets"
+	"github.com/kroonprins/vals/pkg/providers/http"
+	"github.com/kroonprins/vals/pkg/providers/s3"
+	"github.com/kroonprins/vals/pkg/providers/sops"
+	"github.com/kroonprins/vals/pkg/providers/ssm"
+	"github.com/kroonprins/vals/pkg/providers/tfstate"
+	"github.com/kroonprins/vals/pkg/providers/vault"
+)
+
+// Factory creates a provider from the configuration given either as the query parameters of a ref URI
+// or as the `provider` section of a vals config.
+type Factory func(cfg api.StaticConfig) (api.Provider, error)
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{}
+)
+
+func init() {
+	builtins := map[string]Factory{
+		"vault": func(cfg api.StaticConfig) (api.Provider, error) { return vault.New(cfg), nil },
+		// ref+s3://foo/bar?region=ap-northeast-1#/baz
+		// 1. GetObject for the bucket foo and key bar
+		// 2. Then extracts the value for key baz(=/foo/bar/baz) from the result from step 1.
+		"s3": func(cfg api.StaticConfig) (api.Provider, error) { return s3.New(cfg), nil },
+		// ref+gcs://foo/bar?generation=timestamp#/baz
+		// 1. GetObject for the bucket foo and key bar
+		// 2. Then extracts the value for key baz(=/foo/bar/baz) from the result from step 1.
+		"gcs": func(cfg api.StaticConfig) (api.Provider, error) { return gcs.New(cfg), nil },
+		// ref+gitlab://project/variable#key
+		"gitlab": func(cfg api.StaticConfig) (api.Provider, error) { return gitlab.New(cfg), nil },
+		// ref+awsssm://foo/bar?region=ap-northeast-1#/baz
+		// 1. GetParametersByPath for the prefix /foo/bar
+		// 2. Then extracts the value for key baz(=/foo/bar/baz) from the result from step 1.
+		"awsssm": func(cfg api.StaticConfig) (api.Provider, error) { return ssm.New(cfg), nil },
+		"awskms": func(cfg api.StaticConfig) (api.Provider, error) { return awskms.New(cfg), nil },
+		// ref+awssecrets://foo/bar?region=ap-northeast-1#/baz
+		// 1. Get secret for key foo/bar, parse it as yaml
+		// 2. Then extracts the value for key baz) from the result from step 1.
+		"awssecrets":     func(cfg api.StaticConfig) (api.Provider, error) { return awssecrets.New(cfg), nil },
+		"sops":           func(cfg api.StaticConfig) (api.Provider, error) { return sops.New(cfg), nil },
+		"echo":           func(cfg api.StaticConfig) (api.Provider, error) { return echo.New(cfg), nil },
+		"file":           func(cfg api.StaticConfig) (api.Provider, error) { return file.New(cfg), nil },
+		"gcpsecrets":     func(cfg api.StaticConfig) (api.Provider, error) { return gcpsecrets.New(cfg), nil },
+		"googlesheets":   func(cfg api.StaticConfig) (api.Provider, error) { return googlesheets.New(cfg), nil },
+		"tfstate":        func(cfg api.StaticConfig) (api.Provider, error) { return tfstate.New(cfg, ""), nil },
+		"tfstategs":      func(cfg api.StaticConfig) (api.Provider, error) { return tfstate.New(cfg, "gs"), nil },
+		"tfstates3":      func(cfg api.StaticConfig) (api.Provider, error) { return tfstate.New(cfg, "s3"), nil },
+		"tfstateazurerm": func(cfg api.StaticConfig) (api.Provider, error) { return tfstate.New(cfg, "azurerm"), nil },
+		"tfstateremote":  func(cfg api.StaticConfig) (api.Provider, error) { return tfstate.New(cfg, "remote"), nil },
+		"azurekeyvault":  func(cfg api.StaticConfig) (api.Provider, error) { return azurekeyvault.New(cfg), nil },
+		"envsubst":       func(cfg api.StaticConfig) (api.Provider, error) { return envsubst.New(cfg), nil },
+		// ref+exec://ecr-password
+		// Runs the command registered or configured in $VALS_EXEC_COMMANDS_FILE as ecr-password, and returns its STDOUT
+		"exec": func(cfg api.StaticConfig) (api.Provider, error) { return exec.New(cfg), nil },
+		// ref+https://example.com/app.json?header_env=TOKEN#/db/host
+		// GETs https://example.com/app.json with $TOKEN as the Authorization header, parses it as YAML or JSON,
+		// and extracts the value for $.db.host from it
+		"http":  func(cfg api.StaticConfig) (api.Provider, error) { return http.New(cfg, "http"), nil },
+		"https": func(cfg api.StaticConfig) (api.Provider, error) { return http.New(cfg, "https"), nil },
+	}
+
+	// "ssm" is the name historically used in the `provider` section of vals configs
+	builtins["ssm"] = builtins["awsssm"]
+
+	for scheme, f := range builtins {
+		Register(scheme, f)
+	}
+}
+
+// Register makes a provider available under the given scheme, so that it can be referred to
+// with `ref+<scheme>://` or by `name: <scheme>` in a vals config.
+// It panics if the factory is nil or a provider is already registered for the scheme.
+func Register(scheme string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if f == nil {
+		panic(fmt.Sprintf("providers: factory for scheme %q is nil", scheme))
+	}
+	if _, dup := factories[scheme]; dup {
+		panic(fmt.Sprintf("providers: scheme %q is already registered", scheme))
+	}
+	factories[scheme] = f
+}
+
+// Get returns the factory registered for the scheme
+func Get(scheme string) (Factory, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	f, ok := factories[scheme]
+	return f, ok
+}
+
+// New instantiates the provider registered for the scheme.
+// Schemes like `plugin-<name>`, and the schemes of no registered provider, refer to the `vals-provider-<name>` plugins on PATH.
+func New(scheme string, cfg api.StaticConfig) (api.Provider, error) {
+	f, ok := Get(scheme)
+	if !ok {
+		p, err := plugin.New(strings.TrimPrefix(scheme, plugin.SchemePrefix), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("no provider registered for scheme %q: %w", scheme, err)
+		}
+		return p, nil
+	}
+	return f(cfg)
+}
+
+// Schemes returns the sorted list of all the registered schemes
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	schemes := make([]string, 0, len(factories))
+	for s := range factories {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
@@ -0,0 +1,92 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/kroonprins/vals/pkg/api"
+	"github.com/kroonprins/vals/pkg/config"
+)
+
+type constProvider struct {
+	value string
+}
+
+func (p *constProvider) GetString(key string) (string, error) {
+	return p.value, nil
+}
+
+func (p *constProvider) GetStringMap(key string) (map[string]interface{}, error) {
+	return map[string]interface{}{key: p.value}, nil
+}
+
+func TestRegister(t *testing.T) {
+	Register("testconst", func(cfg api.StaticConfig) (api.Provider, error) {
+		return &constProvider{value: cfg.String("value")}, nil
+	})
+
+	p, err := New("testconst", config.Map(map[string]interface{}{"value": "foo"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := p.GetString("any")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "foo" {
+		t.Errorf("unexpected value: expected=%q, got=%q", "foo", got)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected registering a duplicate scheme to panic")
+			}
+		}()
+		Register("testconst", func(cfg api.StaticConfig) (api.Provider, error) { return nil, nil })
+	}()
+}
+
+func TestBuiltins(t *testing.T) {
+	for _, scheme := range []string{"vault", "s3", "gcs", "gitlab", "awsssm", "ssm", "awskms", "awssecrets", "sops", "echo", "file",
+		"gcpsecrets", "googlesheets", "tfstate", "tfstategs", "tfstates3", "tfstateazurerm", "tfstateremote", "azurekeyvault", "envsubst", "exec", "http", "https"} {
+		f, ok := Get(scheme)
+		if !ok {
+			t.Errorf("no builtin provider registered for scheme %q", scheme)
+			continue
+		}
+		p, err := f(config.Map(map[string]interface{}{}))
+		if err != nil {
+			t.Errorf("unexpected error for scheme %q: %v", scheme, err)
+			continue
+		}
+		if _, ok := p.(api.ContextProvider); !ok {
+			t.Errorf("builtin provider for scheme %q does not implement api.ContextProvider", scheme)
+		}
+	}
+
+	if _, err := New("unknown", config.Map(map[string]interface{}{})); err == nil {
+		t.Errorf("expected error for an unknown scheme")
+	}
+}
+
+func TestBuiltins_Writable(t *testing.T) {
+	writable := map[string]bool{
+		"vault": true, "awsssm": true, "ssm": true, "awssecrets": true, "gcpsecrets": true, "azurekeyvault": true, "file": true,
+	}
+
+	for _, scheme := range Schemes() {
+		f, _ := Get(scheme)
+		p, err := f(config.Map(map[string]interface{}{}))
+		if err != nil {
+			t.Errorf("unexpected error for scheme %q: %v", scheme, err)
+			continue
+		}
+		if _, ok := p.(api.WritableProvider); ok != writable[scheme] {
+			t.Errorf("unexpected support for writing by the provider for scheme %q: expected %v, got %v", scheme, writable[scheme], ok)
+		}
+		if _, ok := p.(api.ContextWritableProvider); ok != writable[scheme] {
+			t.Errorf("unexpected support for writing with contexts by the provider for scheme %q: expected %v, got %v", scheme, writable[scheme], ok)
+		}
+	}
+}
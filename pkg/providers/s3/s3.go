@@ -1,12 +1,16 @@
 package s3
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/kroonprins/vals/pkg/api"
@@ -17,6 +21,8 @@ import (
 type provider struct {
 	// Keeping track of s3 services since we need a s3 service per region
 	s3Client s3iface.S3API
+	// mu guards the lazy initialization of s3Client, as the provider is shared by concurrent lookups
+	mu sync.Mutex
 
 	// AWS s3 Parameter store global configuration
 	Region  string
@@ -39,6 +45,10 @@ func New(cfg api.StaticConfig) *provider {
 
 // Get gets an AWS s3 Parameter Store value
 func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
 	split := strings.SplitN(key, "/", 2)
 	bucket, objKey := split[0], split[1]
 
@@ -53,8 +63,12 @@ func (p *provider) GetString(key string) (string, error) {
 		in.VersionId = aws.String(p.Version)
 	}
 
-	out, err := s3Client.GetObject(&in)
+	out, err := s3Client.GetObjectWithContext(ctx, &in)
 	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return "", api.NotFound(fmt.Errorf("getting s3 object: %w", err))
+		}
 		return "", fmt.Errorf("getting s3 object: %w", err)
 	}
 
@@ -69,7 +83,11 @@ func (p *provider) GetString(key string) (string, error) {
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
-	yamlData, err := p.GetString(key)
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	yamlData, err := p.GetStringContext(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -88,6 +106,9 @@ func (p *provider) debugf(msg string, args ...interface{}) {
 }
 
 func (p *provider) getS3Client() s3iface.S3API {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if p.s3Client != nil {
 		return p.s3Client
 	}
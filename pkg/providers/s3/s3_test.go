@@ -1,6 +1,7 @@
 package s3
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -8,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/google/go-cmp/cmp"
@@ -28,7 +30,7 @@ func Output(b string) *s3.GetObjectOutput {
 	}
 }
 
-func (m mockedS3) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+func (m mockedS3) GetObjectWithContext(ctx context.Context, in *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
 	bucket := *in.Bucket
 	if bucket != m.Bucket {
 		return nil, fmt.Errorf("unexpected bucket: %s", bucket)
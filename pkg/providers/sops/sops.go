@@ -1,8 +1,11 @@
 package sops
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 
 	"github.com/kroonprins/vals/pkg/api"
@@ -30,7 +33,11 @@ func New(cfg api.StaticConfig) *provider {
 
 // Get gets an AWS SSM Parameter Store value
 func (p *provider) GetString(key string) (string, error) {
-	cleartext, err := p.decrypt(key, p.format("binary"))
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
+	cleartext, err := p.decrypt(ctx, key, p.format("binary"))
 	if err != nil {
 		return "", err
 	}
@@ -38,7 +45,11 @@ func (p *provider) GetString(key string) (string, error) {
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
-	cleartext, err := p.decrypt(key, p.format("yaml"))
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	cleartext, err := p.decrypt(ctx, key, p.format("yaml"))
 	if err != nil {
 		return nil, err
 	}
@@ -61,7 +72,12 @@ func (p *provider) format(defaultFormat string) string {
 	return defaultFormat
 }
 
-func (p *provider) decrypt(keyOrData, format string) ([]byte, error) {
+// decrypt only checks ctx before decrypting, as the sops library doesn't accept a context
+func (p *provider) decrypt(ctx context.Context, keyOrData, format string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	if p.KeyType == "base64" {
 		blob, err := base64.URLEncoding.DecodeString(keyOrData)
 		if err != nil {
@@ -69,7 +85,11 @@ func (p *provider) decrypt(keyOrData, format string) ([]byte, error) {
 		}
 		return decrypt.Data(blob, format)
 	} else if p.KeyType == "filepath" {
-		return decrypt.File(keyOrData, format)
+		bs, err := decrypt.File(keyOrData, format)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, api.NotFound(err)
+		}
+		return bs, err
 	} else {
 		return nil, fmt.Errorf("unsupported key type %q. It must be one \"base64\" or \"filepath\"", p.KeyType)
 	}
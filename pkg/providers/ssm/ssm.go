@@ -1,11 +1,13 @@
 package ssm
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"github.com/kroonprins/vals/pkg/api"
@@ -13,12 +15,15 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ssm"
 )
 
 type provider struct {
 	// Keeping track of SSM services since we need a SSM service per region
 	ssmClient ssmiface.SSMAPI
+	// mu guards the lazy initialization of ssmClient, as the provider is shared by concurrent lookups
+	mu sync.Mutex
 
 	// AWS SSM Parameter store global configuration
 	Region    string
@@ -26,6 +31,8 @@ type provider struct {
 	Profile   string
 	Mode      string
 	Recursive bool
+	// ParameterType is the type of the parameters created by SetString, which defaults to SecureString
+	ParameterType string
 }
 
 func New(cfg api.StaticConfig) *provider {
@@ -35,17 +42,22 @@ func New(cfg api.StaticConfig) *provider {
 	p.Profile = cfg.String("profile")
 	p.Mode = cfg.String("mode")
 	p.Recursive = cfg.String("recursive") == "true"
+	p.ParameterType = cfg.String("parameter_type")
 
 	return p
 }
 
 // Get gets an AWS SSM Parameter Store value
 func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
 	if key != "" && key[0] != '/' {
 		key = "/" + key
 	}
 	if p.Version != "" {
-		return p.GetStringVersion(key)
+		return p.GetStringVersionContext(ctx, key)
 	}
 
 	ssmClient := p.getSSMClient()
@@ -54,8 +66,11 @@ func (p *provider) GetString(key string) (string, error) {
 		Name:           aws.String(key),
 		WithDecryption: aws.Bool(true),
 	}
-	out, err := ssmClient.GetParameter(&in)
+	out, err := ssmClient.GetParameterWithContext(ctx, &in)
 	if err != nil {
+		if isNotFound(err) {
+			return "", api.NotFound(fmt.Errorf("get parameter: %v", err))
+		}
 		return "", fmt.Errorf("get parameter: %v", err)
 	}
 
@@ -72,6 +87,10 @@ func (p *provider) GetString(key string) (string, error) {
 }
 
 func (p *provider) GetStringVersion(key string) (string, error) {
+	return p.GetStringVersionContext(context.Background(), key)
+}
+
+func (p *provider) GetStringVersionContext(ctx context.Context, key string) (string, error) {
 	if key != "" && key[0] != '/' {
 		key = "/" + key
 	}
@@ -89,7 +108,7 @@ func (p *provider) GetStringVersion(key string) (string, error) {
 	}
 
 	var result string
-	if err := ssmClient.GetParameterHistoryPages(getParameterHistoryInput, func(o *ssm.GetParameterHistoryOutput, lastPage bool) bool {
+	if err := ssmClient.GetParameterHistoryPagesWithContext(ctx, getParameterHistoryInput, func(o *ssm.GetParameterHistoryOutput, lastPage bool) bool {
 		for _, history := range o.Parameters {
 			thisVersion := int64(0)
 
@@ -103,6 +122,9 @@ func (p *provider) GetStringVersion(key string) (string, error) {
 		}
 		return true
 	}); err != nil {
+		if isNotFound(err) {
+			return "", api.NotFound(errors.New(err.Error()))
+		}
 		return "", errors.New(err.Error())
 	}
 	if result != "" {
@@ -110,16 +132,20 @@ func (p *provider) GetStringVersion(key string) (string, error) {
 		return result, nil
 	}
 
-	return "", errors.New("datasource.ssm.Get() out.Parameter.Value is nil")
+	return "", api.NotFound(errors.New("datasource.ssm.Get() out.Parameter.Value is nil"))
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
 	if key != "" && key[0] != '/' {
 		key = "/" + key
 	}
 
 	if p.Mode == "singleparam" {
-		yamlData, err := p.GetString(key)
+		yamlData, err := p.GetStringContext(ctx, key)
 		if err != nil {
 			return nil, err
 		}
@@ -144,7 +170,7 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 	}
 
 	var out ssm.GetParametersByPathOutput
-	if err := ssmClient.GetParametersByPathPages(&in, func(o *ssm.GetParametersByPathOutput, lastPage bool) bool {
+	if err := ssmClient.GetParametersByPathPagesWithContext(ctx, &in, func(o *ssm.GetParametersByPathOutput, lastPage bool) bool {
 		if o != nil && len(o.Parameters) > 0 {
 			out.Parameters = append(out.Parameters, o.Parameters...)
 			return true
@@ -155,7 +181,7 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 	}
 
 	if len(out.Parameters) == 0 {
-		return nil, errors.New("ssm: out.Parameters is empty")
+		return nil, api.NotFound(errors.New("ssm: out.Parameters is empty"))
 	}
 
 	for _, param := range out.Parameters {
@@ -201,11 +227,93 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 	return res, nil
 }
 
+// SetString creates or overwrites the parameter
+func (p *provider) SetString(key string, value string) error {
+	return p.SetStringContext(context.Background(), key, value)
+}
+
+func (p *provider) SetStringContext(ctx context.Context, key string, value string) error {
+	if key != "" && key[0] != '/' {
+		key = "/" + key
+	}
+
+	typ := p.ParameterType
+	if typ == "" {
+		typ = ssm.ParameterTypeSecureString
+	}
+
+	ssmClient := p.getSSMClient()
+
+	_, err := ssmClient.PutParameterWithContext(ctx, &ssm.PutParameterInput{
+		Name:      aws.String(key),
+		Value:     aws.String(value),
+		Type:      aws.String(typ),
+		Overwrite: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("ssm: put parameter %s: %v", key, err)
+	}
+
+	p.debugf("SSM: successfully stored key=%s", key)
+
+	return nil
+}
+
+// SetStringMap stores the map as a parameter per value under the path of the key, like GetStringMap reads them.
+// Nested maps result in nested paths. In the singleparam mode, the map is stored in YAML as a single parameter instead.
+// The existing parameters under the path that aren't in the map are left as they are.
+func (p *provider) SetStringMap(key string, m map[string]interface{}) error {
+	return p.SetStringMapContext(context.Background(), key, m)
+}
+
+func (p *provider) SetStringMapContext(ctx context.Context, key string, m map[string]interface{}) error {
+	if p.Mode == "singleparam" {
+		bs, err := yaml.Marshal(m)
+		if err != nil {
+			return err
+		}
+		return p.SetStringContext(ctx, key, string(bs))
+	}
+
+	for k, v := range m {
+		full := strings.TrimRight(key, "/") + "/" + k
+
+		var err error
+		switch typed := v.(type) {
+		case map[string]interface{}:
+			err = p.SetStringMapContext(ctx, full, typed)
+		case map[interface{}]interface{}, []interface{}:
+			err = fmt.Errorf("ssm: unexpected type of value for %s: %T", full, v)
+		default:
+			err = p.SetStringContext(ctx, full, fmt.Sprintf("%v", v))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IsPathPerKey returns true unless the provider is in the singleparam mode, where maps are stored as single parameters
+func (p *provider) IsPathPerKey(key string) bool {
+	return p.Mode != "singleparam"
+}
+
+// isNotFound returns true if the error is the failure to find the requested parameter
+func isNotFound(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == ssm.ErrCodeParameterNotFound
+}
+
 func (p *provider) debugf(msg string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, msg+"\n", args...)
 }
 
 func (p *provider) getSSMClient() ssmiface.SSMAPI {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if p.ssmClient != nil {
 		return p.ssmClient
 	}
@@ -1,12 +1,14 @@
 package ssm
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"github.com/google/go-cmp/cmp"
@@ -45,7 +47,7 @@ func (m mockedSSM) GetParametersByPath(in *ssm.GetParametersByPathInput) (*ssm.G
 	return m.Output, m.Error
 }
 
-func (m mockedSSM) GetParametersByPathPages(in *ssm.GetParametersByPathInput, fn func(o *ssm.GetParametersByPathOutput, lastPage bool) bool) error {
+func (m mockedSSM) GetParametersByPathPagesWithContext(ctx context.Context, in *ssm.GetParametersByPathInput, fn func(o *ssm.GetParametersByPathOutput, lastPage bool) bool, opts ...request.Option) error {
 	path := *in.Path
 	if path != m.Path {
 		return fmt.Errorf("unexpected path: %s", path)
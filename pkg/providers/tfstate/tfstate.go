@@ -1,7 +1,10 @@
 package tfstate
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"strings"
 
@@ -22,6 +25,15 @@ func New(cfg api.StaticConfig, backend string) *provider {
 
 // Get gets an AWS SSM Parameter Store value
 func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+// GetStringContext only checks ctx before reading the state, as tfstate-lookup doesn't accept a context
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
 	splits := strings.Split(key, "/")
 
 	pos := len(splits) - 1
@@ -49,7 +61,9 @@ func (p *provider) ReadTFState(f, k string) (*tfstate.TFState, error) {
 	switch p.backend {
 	case "":
 		state, err := tfstate.ReadFile(f)
-		if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, api.NotFound(fmt.Errorf("reading tfstate for %s: %w", k, err))
+		} else if err != nil {
 			return nil, fmt.Errorf("reading tfstate for %s: %w", k, err)
 		}
 		return state, nil
@@ -64,5 +78,9 @@ func (p *provider) ReadTFState(f, k string) (*tfstate.TFState, error) {
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
 	return nil, fmt.Errorf("path fragment is not supported for tfstate provider")
 }
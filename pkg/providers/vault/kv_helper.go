@@ -1,7 +1,10 @@
 package vault
 
 import (
+	"context"
 	"errors"
+	"io"
+	"net/url"
 	"path"
 	"strings"
 
@@ -9,7 +12,7 @@ import (
 )
 
 // Taken from https://github.com/hashicorp/vault/blob/master/command/kv_helpers.go
-func kvPreflightVersionRequest(client *api.Client, path string) (string, int, error) {
+func kvPreflightVersionRequest(ctx context.Context, client *api.Client, path string) (string, int, error) {
 	// We don't want to use a wrapping call here so save any custom value and
 	// restore after
 	currentWrappingLookupFunc := client.CurrentWrappingLookupFunc()
@@ -20,7 +23,7 @@ func kvPreflightVersionRequest(client *api.Client, path string) (string, int, er
 	defer client.SetOutputCurlString(currentOutputCurlString)
 
 	r := client.NewRequest("GET", "/v1/sys/internal/ui/mounts/"+path)
-	resp, err := client.RawRequest(r)
+	resp, err := client.RawRequestWithContext(ctx, r)
 	if resp != nil {
 		defer resp.Body.Close()
 	}
@@ -64,8 +67,8 @@ func kvPreflightVersionRequest(client *api.Client, path string) (string, int, er
 	return mountPath, 1, nil
 }
 
-func isKVv2(path string, client *api.Client) (string, bool, error) {
-	mountPath, version, err := kvPreflightVersionRequest(client, path)
+func isKVv2(ctx context.Context, path string, client *api.Client) (string, bool, error) {
+	mountPath, version, err := kvPreflightVersionRequest(ctx, client, path)
 	if err != nil {
 		return "", false, err
 	}
@@ -83,3 +86,78 @@ func addPrefixToVKVPath(p, mountPath, apiPrefix string) string {
 	}
 }
 
+// readWithData is the context-aware equivalent of api.Logical.ReadWithData
+// Taken from https://github.com/hashicorp/vault/blob/master/api/logical.go
+func readWithData(ctx context.Context, client *api.Client, path string, data map[string][]string) (*api.Secret, error) {
+	r := client.NewRequest("GET", "/v1/"+path)
+
+	var values url.Values
+	for k, v := range data {
+		if values == nil {
+			values = make(url.Values)
+		}
+		for _, val := range v {
+			values.Add(k, val)
+		}
+	}
+
+	if values != nil {
+		r.Params = values
+	}
+
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == 404 {
+		secret, parseErr := api.ParseSecret(resp.Body)
+		switch parseErr {
+		case nil:
+		case io.EOF:
+			return nil, nil
+		default:
+			return nil, err
+		}
+		if secret != nil && (len(secret.Warnings) > 0 || len(secret.Data) > 0) {
+			return secret, nil
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return api.ParseSecret(resp.Body)
+}
+
+// write is the context-aware equivalent of api.Logical.Write
+// Taken from https://github.com/hashicorp/vault/blob/master/api/logical.go
+func write(ctx context.Context, client *api.Client, path string, data map[string]interface{}) (*api.Secret, error) {
+	r := client.NewRequest("PUT", "/v1/"+path)
+	if err := r.SetJSONBody(data); err != nil {
+		return nil, err
+	}
+
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == 404 {
+		secret, parseErr := api.ParseSecret(resp.Body)
+		switch parseErr {
+		case nil:
+		case io.EOF:
+			return nil, nil
+		default:
+			return nil, err
+		}
+		if secret != nil && (len(secret.Warnings) > 0 || len(secret.Data) > 0) {
+			return secret, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return api.ParseSecret(resp.Body)
+}
@@ -1,11 +1,14 @@
 package vault
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/kroonprins/vals/pkg/api"
 
@@ -25,6 +28,8 @@ const (
 //	Success! Enabled the kv secrets engine at: mykv/
 type provider struct {
 	client *vault.Client
+	// mu guards the lazy initialization of client, as the provider is shared by concurrent lookups
+	mu sync.Mutex
 
 	Address    string
 	Namespace  string
@@ -94,12 +99,16 @@ func New(cfg api.StaticConfig) *provider {
 
 // Get gets an AWS SSM Parameter Store value
 func (p *provider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *provider) GetStringContext(ctx context.Context, key string) (string, error) {
 	sep := "/"
 	splits := strings.Split(key, sep)
 	path := strings.Join(splits[:len(splits)-1], sep)
 	key = splits[len(splits)-1]
 
-	secret, err := p.GetStringMap(path)
+	secret, err := p.GetStringMapContext(ctx, path)
 	if err != nil {
 		p.debugf("vault: get string failed: path=%q, key=%q", path, key)
 		return "", err
@@ -111,16 +120,20 @@ func (p *provider) GetString(key string) (string, error) {
 		}
 	}
 
-	return "", fmt.Errorf("vault: get string: key %q does not exist in %q", key, path)
+	return "", api.NotFound(fmt.Errorf("vault: get string: key %q does not exist in %q", key, path))
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *provider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
 	cli, err := p.ensureClient()
 	if err != nil {
 		return nil, fmt.Errorf("Cannot create Vault Client: %v", err)
 	}
 
-	mountPath, v2, err := isKVv2(key, cli)
+	mountPath, v2, err := isKVv2(ctx, key, cli)
 	if err != nil {
 		return nil, err
 	}
@@ -136,14 +149,14 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 		data["version"] = []string{p.Version}
 	}
 
-	secret, err := cli.Logical().ReadWithData(key, data)
+	secret, err := readWithData(ctx, cli, key, data)
 	if err != nil {
 		p.debugf("vault: read: key=%q", key)
 		return nil, err
 	}
 
 	if secret == nil {
-		return nil, fmt.Errorf("no secret found for path %q", key)
+		return nil, api.NotFound(fmt.Errorf("no secret found for path %q", key))
 	}
 
 	// Vault KV Version 1
@@ -165,7 +178,64 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 	return res, nil
 }
 
+// SetString stores the value for the field of the secret, like `foo` in `secret/path/foo`.
+// The other fields of the secret are kept.
+func (p *provider) SetString(key string, value string) error {
+	return p.SetStringContext(context.Background(), key, value)
+}
+
+func (p *provider) SetStringContext(ctx context.Context, key string, value string) error {
+	sep := "/"
+	splits := strings.Split(key, sep)
+	path := strings.Join(splits[:len(splits)-1], sep)
+	key = splits[len(splits)-1]
+
+	secret, err := p.GetStringMapContext(ctx, path)
+	if errors.Is(err, api.ErrNotFound) {
+		secret = map[string]interface{}{}
+	} else if err != nil {
+		return err
+	}
+
+	secret[key] = value
+
+	return p.SetStringMapContext(ctx, path, secret)
+}
+
+// SetStringMap writes the map as the fields of the secret, creating a new version of the secret for KV Version 2
+func (p *provider) SetStringMap(key string, m map[string]interface{}) error {
+	return p.SetStringMapContext(context.Background(), key, m)
+}
+
+func (p *provider) SetStringMapContext(ctx context.Context, key string, m map[string]interface{}) error {
+	cli, err := p.ensureClient()
+	if err != nil {
+		return fmt.Errorf("Cannot create Vault Client: %v", err)
+	}
+
+	mountPath, v2, err := isKVv2(ctx, key, cli)
+	if err != nil {
+		return err
+	}
+
+	data := m
+	if v2 {
+		key = addPrefixToVKVPath(key, mountPath, "data")
+		data = map[string]interface{}{"data": m}
+	}
+
+	if _, err := write(ctx, cli, key, data); err != nil {
+		p.debugf("vault: write: key=%q", key)
+		return err
+	}
+
+	return nil
+}
+
 func (p *provider) ensureClient() (*vault.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if p.client == nil {
 		cfg := vault.DefaultConfig()
 		if p.Address != "" {
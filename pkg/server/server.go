@@ -0,0 +1,428 @@
+// Package server implements the HTTP API of `vals serve`, which evaluates refs on behalf of clients
+// that have no access to the backends themselves.
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kroonprins/vals"
+	"github.com/kroonprins/vals/pkg/expansion"
+	"github.com/kroonprins/vals/pkg/plugin"
+	"github.com/kroonprins/vals/pkg/providers"
+)
+
+const (
+	// PathEval evaluates the YAML or JSON documents in the body of POST requests
+	PathEval = "/v1/eval"
+	// PathRef returns the value of the ref in the `uri` query parameter of GET requests
+	PathRef = "/v1/ref"
+	// PathHealth responds with 200 to GET requests without authentication, for liveness probes
+	PathHealth = "/healthz"
+
+	// DefaultMaxBodySize is the maximum size of the bodies of requests when Options.MaxBodySize is zero
+	DefaultMaxBodySize = 10 << 20
+)
+
+// DefaultDeniedSchemes is the schemes of the providers that read the files, the envvars, the commands and the network
+// of the server itself, which requests may use only when listed in Options.AllowedSchemes
+var DefaultDeniedSchemes = []string{"file", "envsubst", "exec", "http", "https"}
+
+// Options configures a Server
+type Options struct {
+	// Runtime evaluates the refs of all the requests, so that the values are cached across requests.
+	// Its Options.CheckRef is set by New to enforce AllowedSchemes and AllowedParams on the refs in the values
+	// obtained for other refs too.
+	Runtime *vals.Runtime
+	// Tokens is the bearer tokens accepted in the `Authorization: Bearer <token>` headers of requests.
+	// Requests without one of the tokens are rejected unless they were authenticated with client certificates,
+	// which is up to the TLS configuration of the http.Server.
+	Tokens []string
+	// AllowedSchemes is the schemes of the providers that requests may use, like `vault` and `awsssm`.
+	// When empty, all the providers are allowed but the plugins and the providers in DefaultDeniedSchemes,
+	// which are allowed only when listed.
+	AllowedSchemes []string
+	// AllowedParams is the query parameters that refs may have to configure the providers, like `region`.
+	// The parameters of vals itself, like `timeout`, are always allowed, but no other parameter is allowed when empty,
+	// as parameters like `address` of the `vault` provider would send the credentials of the server elsewhere.
+	AllowedParams []string
+	// Timeout limits the time spent on evaluating each request. Zero means no timeout.
+	Timeout time.Duration
+	// MaxBodySize is the maximum size of the bodies of requests. Defaults to DefaultMaxBodySize when zero.
+	MaxBodySize int64
+	// AccessLog is where a line is written for each request, without the values and the refs. Nothing is written when nil.
+	AccessLog io.Writer
+}
+
+// Server is the http.Handler of the API of `vals serve`
+type Server struct {
+	opts          Options
+	allowed       map[string]bool
+	allowedParams map[string]bool
+	mux           *http.ServeMux
+
+	logMu sync.Mutex
+	now   func() time.Time
+}
+
+// New returns the Server for the options
+func New(opts Options) (*Server, error) {
+	if opts.Runtime == nil {
+		return nil, errors.New("server: Runtime is required")
+	}
+	for _, t := range opts.Tokens {
+		if t == "" {
+			return nil, errors.New("server: tokens must not be empty")
+		}
+	}
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
+
+	s := &Server{
+		opts:    opts,
+		allowed: map[string]bool{},
+		allowedParams: map[string]bool{
+			vals.ParamTimeout:  true,
+			vals.ParamDefault:  true,
+			vals.ParamOptional: true,
+		},
+		mux: http.NewServeMux(),
+		now: time.Now,
+	}
+	for _, scheme := range opts.AllowedSchemes {
+		s.allowed[scheme] = true
+	}
+	for _, param := range opts.AllowedParams {
+		s.allowedParams[param] = true
+	}
+
+	check := opts.Runtime.Options.CheckRef
+	opts.Runtime.Options.CheckRef = func(scheme string, query url.Values) error {
+		if err := s.checkRef(scheme, query); err != nil {
+			return err
+		}
+		if check != nil {
+			return check(scheme, query)
+		}
+		return nil
+	}
+
+	s.mux.HandleFunc(PathEval, s.handleEval)
+	s.mux.HandleFunc(PathRef, s.handleRef)
+	s.mux.HandleFunc(PathHealth, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	return s, nil
+}
+
+// ServeHTTP authenticates the request, serves it and writes the access log
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := s.now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+	identity, ok := s.authenticate(r)
+	switch {
+	case r.URL.Path == PathHealth:
+		s.mux.ServeHTTP(rec, r)
+	case !ok:
+		rec.Header().Set("WWW-Authenticate", `Bearer realm="vals"`)
+		writeError(rec, http.StatusUnauthorized, errors.New("unauthorized"))
+	default:
+		s.mux.ServeHTTP(rec, r)
+	}
+
+	s.logAccess(r, identity, rec.status, s.now().Sub(start))
+}
+
+// authenticate returns the identity of the client for the access log, like `token:1` or `cert:CN`,
+// and false when the client isn't authenticated
+func (s *Server) authenticate(r *http.Request) (string, bool) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		return "cert:" + r.TLS.VerifiedChains[0][0].Subject.CommonName, true
+	}
+
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token := strings.TrimPrefix(auth, "Bearer ")
+		for i, t := range s.opts.Tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				return "token:" + strconv.Itoa(i+1), true
+			}
+		}
+	}
+
+	return "-", false
+}
+
+func (s *Server) logAccess(r *http.Request, identity string, status int, d time.Duration) {
+	if s.opts.AccessLog == nil {
+		return
+	}
+
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+
+	// The query is left out, as it contains the ref of GET /v1/ref
+	fmt.Fprintf(s.opts.AccessLog, "%s %s %s %q %d %s\n",
+		s.now().UTC().Format(time.RFC3339), r.RemoteAddr, identity, r.Method+" "+r.URL.Path, status, d.Round(time.Millisecond))
+}
+
+func (s *Server) context(r *http.Request) (context.Context, context.CancelFunc) {
+	if s.opts.Timeout > 0 {
+		return context.WithTimeout(r.Context(), s.opts.Timeout)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// handleEval evaluates the YAML or JSON documents in the body, and responds with the documents in YAML,
+// or in JSON when either the `format` query parameter is `json` or the request is `application/json`
+func (s *Server) handleEval(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	format, err := outputFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.opts.MaxBodySize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+
+	nodes, err := decodeNodes(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	refs, err := vals.ExtractRefs(nodes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.checkRefs(refs); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	ctx, cancel := s.context(r)
+	defer cancel()
+
+	res, err := s.opts.Runtime.EvalNodes(ctx, nodes)
+	if err != nil {
+		writeEvalError(w, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := vals.Output(&buf, format, res); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "application/yaml")
+	}
+	w.Write(buf.Bytes())
+}
+
+// handleRef responds with the value of the ref in the `uri` query parameter as `{"value": ...}`.
+// The `ref+` prefix of the ref is optional.
+func (s *Server) handleRef(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	uri := r.URL.Query().Get("uri")
+	if uri == "" {
+		writeError(w, http.StatusBadRequest, errors.New("the uri query parameter is required"))
+		return
+	}
+	if ixs := expansion.DefaultRefRegexp.FindStringSubmatchIndex(uri); ixs == nil || ixs[0] != 0 {
+		uri = "ref+" + uri
+	}
+
+	var doc yaml.Node
+	if err := doc.Encode(map[string]string{"value": uri}); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	nodes := []yaml.Node{{Kind: yaml.DocumentNode, Content: []*yaml.Node{&doc}}}
+
+	refs, err := vals.ExtractRefs(nodes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(refs) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ref %q", uri))
+		return
+	}
+	if err := s.checkRefs(refs); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	ctx, cancel := s.context(r)
+	defer cancel()
+
+	res, err := s.opts.Runtime.EvalNodes(ctx, nodes)
+	if err != nil {
+		writeEvalError(w, err)
+		return
+	}
+
+	var m map[string]interface{}
+	if err := res[0].Decode(&m); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	v, ok := m["value"]
+	if !ok {
+		// The ref is optional and its secret doesn't exist
+		writeError(w, http.StatusNotFound, errors.New("the ref has no value"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": v})
+}
+
+// errNotAllowed is the error of the refs that use a provider or a query parameter that isn't allowed
+var errNotAllowed = errors.New("not allowed")
+
+// checkRefs returns the error when any of the refs uses a provider or a query parameter that isn't allowed
+func (s *Server) checkRefs(refs []vals.Ref) error {
+	for _, ref := range refs {
+		if err := s.checkRef(ref.Scheme, ref.Query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRef returns the error wrapping errNotAllowed when the provider or any of the query parameters isn't allowed.
+// It is called for the refs in the requests before evaluating them, and by the runtime for every ref it evaluates,
+// as the values obtained for refs may contain other refs.
+func (s *Server) checkRef(scheme string, query url.Values) error {
+	if !s.allowedScheme(scheme) {
+		return fmt.Errorf("provider %q is %w", scheme, errNotAllowed)
+	}
+	for param := range query {
+		if !s.allowedParams[param] {
+			return fmt.Errorf("query parameter %q is %w", param, errNotAllowed)
+		}
+	}
+	return nil
+}
+
+// allowedScheme returns true when the scheme is either listed in Options.AllowedSchemes,
+// or allowed by default when Options.AllowedSchemes is empty
+func (s *Server) allowedScheme(scheme string) bool {
+	if len(s.allowed) > 0 {
+		return s.allowed[scheme]
+	}
+	for _, denied := range DefaultDeniedSchemes {
+		if scheme == denied {
+			return false
+		}
+	}
+	// Plugins are the `plugin-` schemes and the schemes of no registered provider
+	if _, ok := providers.Get(scheme); !ok || strings.HasPrefix(scheme, plugin.SchemePrefix) {
+		return false
+	}
+	return true
+}
+
+func outputFormat(r *http.Request) (string, error) {
+	switch f := r.URL.Query().Get("format"); f {
+	case "json", "yaml":
+		return f, nil
+	case "":
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			return "json", nil
+		}
+		return "yaml", nil
+	default:
+		return "", fmt.Errorf("unsupported format %q: expected either \"yaml\" or \"json\"", f)
+	}
+}
+
+func decodeNodes(body []byte) ([]yaml.Node, error) {
+	var nodes []yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(body))
+	for {
+		var node yaml.Node
+		if err := decoder.Decode(&node); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("no document found")
+	}
+	return nodes, nil
+}
+
+// writeEvalError responds with the failures to evaluate refs, which are either timeouts, failures of the providers,
+// or refs that aren't allowed in the values obtained for other refs
+func writeEvalError(w http.ResponseWriter, err error) {
+	status := http.StatusUnprocessableEntity
+	switch {
+	case errors.Is(err, errNotAllowed):
+		status = http.StatusForbidden
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	}
+	writeError(w, status, err)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		bs = []byte(`{"error":"encoding the response failed"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(bs, '\n'))
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
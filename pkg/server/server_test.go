@@ -0,0 +1,374 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kroonprins/vals"
+)
+
+func newTestServer(t *testing.T, opts Options) (*httptest.Server, *bytes.Buffer) {
+	t.Helper()
+
+	runtime, err := vals.New(vals.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var log bytes.Buffer
+	opts.Runtime = runtime
+	opts.AccessLog = &log
+
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	return ts, &log
+}
+
+func do(t *testing.T, method, url, token, contentType, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	bs, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.StatusCode, string(bs)
+}
+
+func TestServer(t *testing.T) {
+	ts, log := newTestServer(t, Options{
+		Tokens:         []string{"first", "second"},
+		AllowedSchemes: []string{"echo"},
+	})
+
+	testcases := []struct {
+		name        string
+		method      string
+		path        string
+		token       string
+		contentType string
+		body        string
+		status      int
+		expected    string
+	}{
+		{
+			name:     "health without token",
+			method:   http.MethodGet,
+			path:     PathHealth,
+			status:   http.StatusOK,
+			expected: "ok\n",
+		},
+		{
+			name:     "eval without token",
+			method:   http.MethodPost,
+			path:     PathEval,
+			body:     "foo: ref+echo://bar\n",
+			status:   http.StatusUnauthorized,
+			expected: `{"error":"unauthorized"}` + "\n",
+		},
+		{
+			name:     "eval with wrong token",
+			method:   http.MethodPost,
+			path:     PathEval,
+			token:    "wrong",
+			body:     "foo: ref+echo://bar\n",
+			status:   http.StatusUnauthorized,
+			expected: `{"error":"unauthorized"}` + "\n",
+		},
+		{
+			name:     "eval yaml",
+			method:   http.MethodPost,
+			path:     PathEval,
+			token:    "second",
+			body:     "foo: ref+echo://bar\n---\nbaz: ref+echo://qux\n",
+			status:   http.StatusOK,
+			expected: "foo: bar\n---\nbaz: qux\n",
+		},
+		{
+			name:        "eval json",
+			method:      http.MethodPost,
+			path:        PathEval,
+			token:       "first",
+			contentType: "application/json",
+			body:        `{"foo": "ref+echo://bar"}`,
+			status:      http.StatusOK,
+			expected:    `{"foo":"bar"}` + "\n",
+		},
+		{
+			name:     "eval with disallowed provider",
+			method:   http.MethodPost,
+			path:     PathEval,
+			token:    "first",
+			body:     "foo: ref+echo://bar\nbaz: ref+file:///etc/passwd\n",
+			status:   http.StatusForbidden,
+			expected: `{"error":"provider \"file\" is not allowed"}` + "\n",
+		},
+		{
+			name:     "eval with disallowed provider in fallback",
+			method:   http.MethodPost,
+			path:     PathEval,
+			token:    "first",
+			body:     "foo: ref+echo://bar||file:///etc/passwd\n",
+			status:   http.StatusForbidden,
+			expected: `{"error":"provider \"file\" is not allowed"}` + "\n",
+		},
+		{
+			name:     "eval with disallowed query parameter",
+			method:   http.MethodPost,
+			path:     PathEval,
+			token:    "first",
+			body:     "foo: ref+echo://bar?address=https://example.com\n",
+			status:   http.StatusForbidden,
+			expected: `{"error":"query parameter \"address\" is not allowed"}` + "\n",
+		},
+		{
+			name:   "eval with invalid document",
+			method: http.MethodPost,
+			path:   PathEval,
+			token:  "first",
+			body:   "foo: [\n",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:     "eval with get",
+			method:   http.MethodGet,
+			path:     PathEval,
+			token:    "first",
+			status:   http.StatusMethodNotAllowed,
+			expected: `{"error":"method GET is not allowed"}` + "\n",
+		},
+		{
+			name:     "ref",
+			method:   http.MethodGet,
+			path:     PathRef + "?uri=" + url.QueryEscape("echo://foo/bar"),
+			token:    "first",
+			status:   http.StatusOK,
+			expected: `{"value":"foo/bar"}` + "\n",
+		},
+		{
+			name:     "ref with prefix and transform",
+			method:   http.MethodGet,
+			path:     PathRef + "?uri=" + url.QueryEscape("ref+echo://foo|upper"),
+			token:    "first",
+			status:   http.StatusOK,
+			expected: `{"value":"FOO"}` + "\n",
+		},
+		{
+			name:     "ref with disallowed nested provider",
+			method:   http.MethodGet,
+			path:     PathRef + "?uri=" + url.QueryEscape("echo://{{ref+file:///etc/passwd}}"),
+			token:    "first",
+			status:   http.StatusForbidden,
+			expected: `{"error":"provider \"file\" is not allowed"}` + "\n",
+		},
+		{
+			name:     "ref without uri",
+			method:   http.MethodGet,
+			path:     PathRef,
+			token:    "first",
+			status:   http.StatusBadRequest,
+			expected: `{"error":"the uri query parameter is required"}` + "\n",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := do(t, tc.method, ts.URL+tc.path, tc.token, tc.contentType, tc.body)
+			if status != tc.status {
+				t.Errorf("unexpected status: expected %d, got %d: %s", tc.status, status, body)
+			}
+			if tc.expected != "" && body != tc.expected {
+				t.Errorf("unexpected body: expected %q, got %q", tc.expected, body)
+			}
+		})
+	}
+
+	logged := log.String()
+	for _, expected := range []string{
+		`- "POST /v1/eval" 401`,
+		`token:2 "POST /v1/eval" 200`,
+		`token:1 "GET /v1/ref" 200`,
+	} {
+		if !strings.Contains(logged, expected) {
+			t.Errorf("expected the access log to contain %q, got:\n%s", expected, logged)
+		}
+	}
+	if strings.Contains(logged, "foo") {
+		t.Errorf("expected the access log not to contain the refs, got:\n%s", logged)
+	}
+}
+
+func TestServer_DefaultDeniedSchemes(t *testing.T) {
+	ts, _ := newTestServer(t, Options{Tokens: []string{"token"}})
+
+	status, body := do(t, http.MethodGet, ts.URL+PathRef+"?uri="+url.QueryEscape("ref+echo://foo"), "token", "", "")
+	if status != http.StatusOK {
+		t.Errorf("unexpected status of echo: expected %d, got %d: %s", http.StatusOK, status, body)
+	}
+
+	for _, uri := range []string{
+		"ref+file:///etc/hostname",
+		"ref+envsubst://$HOME",
+		"ref+exec://cmd",
+		"ref+http://localhost/",
+		"ref+https://localhost/",
+		"ref+plugin-store://foo",
+		"ref+unregistered://foo",
+	} {
+		status, body := do(t, http.MethodGet, ts.URL+PathRef+"?uri="+url.QueryEscape(uri), "token", "", "")
+		if status != http.StatusForbidden {
+			t.Errorf("unexpected status of %s: expected %d, got %d: %s", uri, http.StatusForbidden, status, body)
+		}
+	}
+}
+
+func TestServer_NestedDeniedSchemes(t *testing.T) {
+	ts, _ := newTestServer(t, Options{Tokens: []string{"token"}})
+
+	f := filepath.Join(t.TempDir(), "secretfile")
+	if err := os.WriteFile(f, []byte("s3cr3t"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The value of the echo ref is a document with the file ref, which is evaluated in turn
+	escaped := strings.NewReplacer("+", "%2B", "/", "%2F").Replace("ref+file://" + f)
+	status, body := do(t, http.MethodPost, ts.URL+PathEval, "token", "", `y: "ref+echo://h/a: `+escaped+`|yamldec"`+"\n")
+	if status != http.StatusForbidden {
+		t.Errorf("unexpected status: expected %d, got %d: %s", http.StatusForbidden, status, body)
+	}
+	if strings.Contains(body, "s3cr3t") {
+		t.Errorf("the response contains the content of the file: %s", body)
+	}
+}
+
+func TestServer_Optional(t *testing.T) {
+	ts, _ := newTestServer(t, Options{Tokens: []string{"token"}, AllowedSchemes: []string{"file"}})
+
+	status, body := do(t, http.MethodGet, ts.URL+PathRef+"?uri="+url.QueryEscape("ref?+file:///nonexistent/vals.yaml#/foo"), "token", "", "")
+	if status != http.StatusNotFound {
+		t.Errorf("unexpected status: expected %d, got %d: %s", http.StatusNotFound, status, body)
+	}
+}
+
+func TestServer_ClientCertificate(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "job-1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime, err := vals.New(vals.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	s, err := New(Options{Runtime: runtime, AccessLog: &log})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	ts := httptest.NewUnstartedServer(s)
+	ts.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(client *http.Client) int {
+		res, err := client.Get(ts.URL + PathRef + "?uri=" + url.QueryEscape("echo://foo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if status := get(ts.Client()); status != http.StatusUnauthorized {
+		t.Errorf("unexpected status without client certificate: expected %d, got %d", http.StatusUnauthorized, status)
+	}
+
+	transport := ts.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{{
+		Certificate: [][]byte{clientDER},
+		PrivateKey:  clientKey,
+	}}
+	client := &http.Client{Transport: transport}
+	if status := get(client); status != http.StatusOK {
+		t.Errorf("unexpected status with client certificate: expected %d, got %d", http.StatusOK, status)
+	}
+
+	if !strings.Contains(log.String(), `cert:job-1 "GET /v1/ref" 200`) {
+		t.Errorf("expected the access log to contain the client, got:\n%s", log.String())
+	}
+}
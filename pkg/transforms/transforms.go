@@ -0,0 +1,291 @@
+package transforms
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Func transforms the value obtained for a ref, like a string or a map, with the arguments given in the ref.
+// For example, `ref+vault://secret/db#/cert|indent 4` calls the "indent" transform with the argument "4".
+type Func func(v interface{}, args ...string) (interface{}, error)
+
+// Call is a transform to be applied to the value of a ref
+type Call struct {
+	Name string
+	Args []string
+}
+
+var (
+	mu    sync.RWMutex
+	funcs = map[string]Func{}
+)
+
+func init() {
+	builtins := map[string]Func{
+		"b64enc":  stringFunc(func(s string) (interface{}, error) { return base64.StdEncoding.EncodeToString([]byte(s)), nil }),
+		"b64dec":  stringFunc(b64dec),
+		"hexenc":  stringFunc(func(s string) (interface{}, error) { return hex.EncodeToString([]byte(s)), nil }),
+		"hexdec":  stringFunc(hexdec),
+		"trim":    stringFunc(func(s string) (interface{}, error) { return strings.TrimSpace(s), nil }),
+		"upper":   stringFunc(func(s string) (interface{}, error) { return strings.ToUpper(s), nil }),
+		"lower":   stringFunc(func(s string) (interface{}, error) { return strings.ToLower(s), nil }),
+		"sha256":  stringFunc(func(s string) (interface{}, error) { return fmt.Sprintf("%x", sha256.Sum256([]byte(s))), nil }),
+		"jsonenc": jsonenc,
+		"jsondec": stringFunc(jsondec),
+		"yamlenc": yamlenc,
+		"yamldec": stringFunc(yamldec),
+		"indent":  indent,
+	}
+
+	for name, f := range builtins {
+		Register(name, f)
+	}
+}
+
+// Register makes the transform available under the name, so that it can be applied with `|<name>` in refs.
+// It panics if the function is nil or a transform is already registered for the name.
+func Register(name string, f Func) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if f == nil {
+		panic(fmt.Sprintf("transforms: function for %q is nil", name))
+	}
+	if _, dup := funcs[name]; dup {
+		panic(fmt.Sprintf("transforms: %q is already registered", name))
+	}
+	funcs[name] = f
+}
+
+// Get returns the transform registered for the name
+func Get(name string) (Func, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	f, ok := funcs[name]
+	return f, ok
+}
+
+// Names returns the sorted list of all the registered transforms
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(funcs))
+	for n := range funcs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Split separates the trailing `|name args...` segments of the ref from the rest of the ref.
+// Only the segments that start with the names of registered transforms are separated,
+// so that `|` in the ref itself, like in JMESPath expressions, is left as is.
+func Split(ref string) (string, []Call) {
+	parts := strings.Split(ref, "|")
+
+	var calls []Call
+	i := len(parts) - 1
+	for ; i > 0; i-- {
+		fields := strings.Fields(parts[i])
+		if len(fields) == 0 {
+			break
+		}
+		if _, ok := Get(fields[0]); !ok {
+			break
+		}
+		calls = append([]Call{{Name: fields[0], Args: fields[1:]}}, calls...)
+	}
+
+	return strings.Join(parts[:i+1], "|"), calls
+}
+
+// Apply applies the transforms to the value in order
+func Apply(v interface{}, calls []Call) (interface{}, error) {
+	for _, c := range calls {
+		f, ok := Get(c.Name)
+		if !ok {
+			return nil, fmt.Errorf("no transform registered for %q", c.Name)
+		}
+		var err error
+		v, err = f(v, c.Args...)
+		if err != nil {
+			return nil, fmt.Errorf("transform %s: %w", c.Name, err)
+		}
+	}
+	return v, nil
+}
+
+// stringFunc returns the transform that applies f to string values without arguments.
+// Numbers, booleans and null are formatted as strings beforehand.
+func stringFunc(f func(string) (interface{}, error)) Func {
+	return func(v interface{}, args ...string) (interface{}, error) {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("unexpected arguments %v", args)
+		}
+		s, err := toString(v)
+		if err != nil {
+			return nil, err
+		}
+		return f(s)
+	}
+}
+
+func toString(v interface{}) (string, error) {
+	switch typed := v.(type) {
+	case string:
+		return typed, nil
+	case nil:
+		return "null", nil
+	case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+		return "", fmt.Errorf("unexpected type of value: expected a string, got %T", v)
+	default:
+		return fmt.Sprintf("%v", v), nil
+	}
+}
+
+func b64dec(s string) (interface{}, error) {
+	bs, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	return string(bs), nil
+}
+
+func hexdec(s string) (interface{}, error) {
+	bs, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	return string(bs), nil
+}
+
+func jsonenc(v interface{}, args ...string) (interface{}, error) {
+	if len(args) > 0 {
+		return nil, fmt.Errorf("unexpected arguments %v", args)
+	}
+	bs, err := json.Marshal(ToJSONCompatible(v))
+	if err != nil {
+		return nil, err
+	}
+	return string(bs), nil
+}
+
+func jsondec(s string) (interface{}, error) {
+	var v interface{}
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return fromJSON(v), nil
+}
+
+func yamlenc(v interface{}, args ...string) (interface{}, error) {
+	if len(args) > 0 {
+		return nil, fmt.Errorf("unexpected arguments %v", args)
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func yamldec(s string) (interface{}, error) {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// indent prefixes every non-empty line of the value with the given number of spaces
+func indent(v interface{}, args ...string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected the number of spaces as the only argument, got %v", args)
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid number of spaces %q", args[0])
+	}
+	s, err := toString(v)
+	if err != nil {
+		return nil, err
+	}
+
+	pad := strings.Repeat(" ", n)
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = pad + l
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// ToJSONCompatible converts the maps with non-string keys in v, which are not supported by encoding/json
+func ToJSONCompatible(v interface{}) interface{} {
+	switch typed := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			m[k] = ToJSONCompatible(v)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			m[fmt.Sprintf("%v", k)] = ToJSONCompatible(v)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(typed))
+		for i, v := range typed {
+			a[i] = ToJSONCompatible(v)
+		}
+		return a
+	default:
+		return v
+	}
+}
+
+// fromJSON converts json.Numbers into ints when possible, or float64s otherwise, like YAML decoding does
+func fromJSON(v interface{}) interface{} {
+	switch typed := v.(type) {
+	case json.Number:
+		if i, err := typed.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := typed.Float64()
+		return f
+	case map[string]interface{}:
+		for k, v := range typed {
+			typed[k] = fromJSON(v)
+		}
+		return typed
+	case []interface{}:
+		for i, v := range typed {
+			typed[i] = fromJSON(v)
+		}
+		return typed
+	default:
+		return v
+	}
+}
@@ -0,0 +1,125 @@
+package transforms
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplit(t *testing.T) {
+	testcases := []struct {
+		ref      string
+		expected string
+		calls    []Call
+	}{
+		{
+			ref:      "vault://secret/db#/pw",
+			expected: "vault://secret/db#/pw",
+		},
+		{
+			ref:      "vault://secret/db#/pw|b64dec|trim",
+			expected: "vault://secret/db#/pw",
+			calls:    []Call{{Name: "b64dec", Args: []string{}}, {Name: "trim", Args: []string{}}},
+		},
+		{
+			ref:      "file://cert.pem|indent 4",
+			expected: "file://cert.pem",
+			calls:    []Call{{Name: "indent", Args: []string{"4"}}},
+		},
+		{
+			ref:      "awssecrets://users#jmespath=users[0] | name|upper",
+			expected: "awssecrets://users#jmespath=users[0] | name",
+			calls:    []Call{{Name: "upper", Args: []string{}}},
+		},
+		{
+			ref:      "awssecrets://users#jmespath=a || b",
+			expected: "awssecrets://users#jmespath=a || b",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.ref, func(t *testing.T) {
+			ref, calls := Split(tc.ref)
+			if ref != tc.expected {
+				t.Errorf("unexpected ref: expected %q, got %q", tc.expected, ref)
+			}
+			if diff := cmp.Diff(tc.calls, calls); diff != "" {
+				t.Errorf("unexpected calls: -(expected), +(got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	testcases := []struct {
+		name     string
+		in       interface{}
+		calls    []Call
+		expected interface{}
+		err      bool
+	}{
+		{name: "b64enc", in: "foo", calls: []Call{{Name: "b64enc"}}, expected: "Zm9v"},
+		{name: "b64dec", in: "Zm9v\n", calls: []Call{{Name: "b64dec"}}, expected: "foo"},
+		{name: "b64dec invalid", in: "!!", calls: []Call{{Name: "b64dec"}}, err: true},
+		{name: "hexenc", in: "foo", calls: []Call{{Name: "hexenc"}}, expected: "666f6f"},
+		{name: "hexdec", in: "666f6f", calls: []Call{{Name: "hexdec"}}, expected: "foo"},
+		{name: "trim", in: " foo\n", calls: []Call{{Name: "trim"}}, expected: "foo"},
+		{name: "upper", in: "foo", calls: []Call{{Name: "upper"}}, expected: "FOO"},
+		{name: "lower", in: "FOO", calls: []Call{{Name: "lower"}}, expected: "foo"},
+		{name: "sha256", in: "foo", calls: []Call{{Name: "sha256"}}, expected: "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"},
+		{name: "sha256 number", in: 1, calls: []Call{{Name: "sha256"}}, expected: "6b86b273ff34fce19d6b804eff5a3f5747ada4eaa22f1d49c01e52ddb7875b4b"},
+		{name: "jsonenc", in: map[string]interface{}{"a": map[interface{}]interface{}{"b": 1}}, calls: []Call{{Name: "jsonenc"}}, expected: `{"a":{"b":1}}`},
+		{name: "jsondec", in: `{"a":{"b":1,"c":1.5}}`, calls: []Call{{Name: "jsondec"}}, expected: map[string]interface{}{"a": map[string]interface{}{"b": 1, "c": 1.5}}},
+		{name: "yamlenc", in: map[string]interface{}{"a": "b"}, calls: []Call{{Name: "yamlenc"}}, expected: "a: b"},
+		{name: "yamldec", in: "a: b", calls: []Call{{Name: "yamldec"}}, expected: map[string]interface{}{"a": "b"}},
+		{name: "indent", in: "a\n\nb", calls: []Call{{Name: "indent", Args: []string{"2"}}}, expected: "  a\n\n  b"},
+		{name: "indent without args", in: "a", calls: []Call{{Name: "indent"}}, err: true},
+		{name: "pipeline", in: "  Zm9vCg==", calls: []Call{{Name: "trim"}, {Name: "b64dec"}, {Name: "trim"}, {Name: "upper"}}, expected: "FOO"},
+		{name: "map to string transform", in: map[string]interface{}{"a": "b"}, calls: []Call{{Name: "trim"}}, err: true},
+		{name: "unexpected args", in: "a", calls: []Call{{Name: "trim", Args: []string{"x"}}}, err: true},
+		{name: "unknown", in: "a", calls: []Call{{Name: "nope"}}, err: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := Apply(tc.in, tc.calls)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error, got %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("testreverse", func(v interface{}, args ...string) (interface{}, error) {
+		s := v.(string)
+		r := []rune(s)
+		for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+			r[i], r[j] = r[j], r[i]
+		}
+		return string(r), nil
+	})
+
+	ref, calls := Split("echo://abc|testreverse")
+	if ref != "echo://abc" || len(calls) != 1 {
+		t.Fatalf("unexpected split: %q, %v", ref, calls)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic for a duplicate transform")
+			}
+		}()
+		Register("trim", func(v interface{}, args ...string) (interface{}, error) { return v, nil })
+	}()
+}
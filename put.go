@@ -0,0 +1,196 @@
+package vals
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kroonprins/vals/pkg/api"
+	"github.com/kroonprins/vals/pkg/expansion"
+	"github.com/kroonprins/vals/pkg/transforms"
+)
+
+// Put stores the value at the ref, like `ref+vault://secret/foo#/key`. The `ref+` prefix is optional.
+// The ref is parsed as when getting values, and its query parameters configure the provider in the same way.
+//
+// With a fragment, the value is set at the path in the document, which is read from the provider,
+// updated and stored back as a whole. Missing documents and maps along the path are created.
+// For the providers that implement api.PathPerKeyProvider, like `awsssm`, only the value at the path joined with
+// the fragment is stored instead.
+// The provider for the scheme of the ref must implement api.WritableProvider.
+//
+// The values cached for the refs to the path, or to the paths below or above it, are evicted,
+// so that they are obtained again from the provider afterwards.
+func (r *Runtime) Put(ctx context.Context, ref string, value string) error {
+	if ixs := expansion.DefaultRefRegexp.FindStringSubmatchIndex(ref); ixs != nil && ixs[0] == 0 {
+		// Nested refs contain `+` which ends the ref for the regexp
+		ref = strings.TrimSuffix(ref[ixs[6]:], "+")
+	}
+
+	if expansion.HasNested(ref) {
+		expand := r.expander()
+		expand.Lookup = r.lookupFunc(ctx)
+		resolved, err := expand.ResolveNested(ref)
+		if err != nil {
+			return err
+		}
+		ref = resolved
+	}
+
+	if len(splitFallbacks(ref)) > 1 {
+		return fmt.Errorf("put %s: fallbacks can't be used with put", ref)
+	}
+	if _, calls := transforms.Split(ref); len(calls) > 0 {
+		return fmt.Errorf("put %s: transforms can't be used with put", ref)
+	}
+
+	uri, err := url.Parse(ref)
+	if err != nil {
+		return err
+	}
+
+	query := uri.Query()
+	for _, param := range []string{ParamTimeout, ParamDefault, ParamOptional} {
+		if query.Has(param) && !isProviderParam(uri.Scheme, param) {
+			return fmt.Errorf("put %s: %s can't be used with put", ref, param)
+		}
+	}
+
+	p, err := r.providerFor(uri, query)
+	if err != nil {
+		return err
+	}
+
+	wp, ok := p.(api.WritableProvider)
+	if !ok {
+		return fmt.Errorf("put %s: the provider for %s doesn't support writing values", ref, uri.Scheme)
+	}
+
+	path := refPath(uri)
+
+	frag := strings.TrimPrefix(uri.Fragment, "/")
+	if strings.HasPrefix(frag, FragmentJMESPath) {
+		return fmt.Errorf("put %s: jmespath expressions can't be used with put", ref)
+	}
+	defer r.evict(uri)
+
+	if frag == "" {
+		if err := api.SetString(ctx, wp, path, value); err != nil {
+			return fmt.Errorf("put %s: %w", ref, err)
+		}
+		return nil
+	}
+
+	if pp, ok := wp.(api.PathPerKeyProvider); ok && pp.IsPathPerKey(path) {
+		if err := api.SetString(ctx, wp, strings.TrimSuffix(path, "/")+"/"+frag, value); err != nil {
+			return fmt.Errorf("put %s: %w", ref, err)
+		}
+		return nil
+	}
+
+	doc, err := api.GetStringMap(ctx, p, path)
+	if errors.Is(err, api.ErrNotFound) {
+		doc = map[string]interface{}{}
+	} else if err != nil {
+		return fmt.Errorf("put %s: %w", ref, err)
+	}
+
+	if err := setValueAtFragment(doc, frag, value); err != nil {
+		return fmt.Errorf("put %s: %w", ref, err)
+	}
+
+	if err := api.SetStringMap(ctx, wp, path, doc); err != nil {
+		return fmt.Errorf("put %s: %w", ref, err)
+	}
+
+	return nil
+}
+
+// evict removes the cached values of the refs with the scheme of the ref URI whose paths are the path of the ref URI,
+// or below or above it, as storing a value may change the documents and the values under the paths of the refs
+func (r *Runtime) evict(uri *url.URL) {
+	path := strings.TrimSuffix(refPath(uri), "/")
+	within := func(p, dir string) bool {
+		return p == dir || strings.HasPrefix(p, dir+"/")
+	}
+
+	for _, c := range []Cache{r.strCache, r.docCache} {
+		for _, k := range c.Keys() {
+			s, ok := k.(string)
+			if !ok {
+				continue
+			}
+			cached, err := url.Parse(s)
+			if err != nil || cached.Scheme != uri.Scheme {
+				continue
+			}
+			if p := strings.TrimSuffix(refPath(cached), "/"); within(p, path) || within(path, p) {
+				c.Remove(k)
+			}
+		}
+	}
+}
+
+// setValueAtFragment sets the value at the slash-separated path in the document, like `db/password`,
+// creating the missing maps along the path. Items of lists are referred to by their indexes, like `servers/0/host`,
+// and must exist, as when getting values.
+func setValueAtFragment(doc map[string]interface{}, frag string, value string) error {
+	keys := strings.Split(frag, "/")
+
+	last := keys[len(keys)-1]
+	if last == "*" || last == "" {
+		return fmt.Errorf("invalid fragment %q: expected the path to a key", frag)
+	}
+
+	// set replaces the child of the current map or list, and get returns it
+	var (
+		get func(k string) interface{}
+		set func(k string, v interface{})
+	)
+	var obj interface{} = doc
+	for i, k := range keys {
+		switch t := obj.(type) {
+		case map[string]interface{}:
+			get = func(k string) interface{} { return t[k] }
+			set = func(k string, v interface{}) { t[k] = v }
+		case []interface{}:
+			idx, err := strconv.Atoi(k)
+			if err != nil {
+				return fmt.Errorf("unexpected key at %d=%s in %v: expected an index of a list", i, k, keys)
+			}
+			if idx < 0 || idx >= len(t) {
+				return fmt.Errorf("index at %d=%s in %v is out of range: the list has %d items", i, k, keys, len(t))
+			}
+			get = func(string) interface{} { return t[idx] }
+			set = func(_ string, v interface{}) { t[idx] = v }
+		}
+
+		if i == len(keys)-1 {
+			set(k, value)
+			return nil
+		}
+
+		switch next := get(k).(type) {
+		case nil:
+			created := map[string]interface{}{}
+			set(k, created)
+			obj = created
+		case map[string]interface{}, []interface{}:
+			obj = next
+		case map[interface{}]interface{}:
+			converted := make(map[string]interface{}, len(next))
+			for k, v := range next {
+				converted[fmt.Sprintf("%v", k)] = v
+			}
+			set(k, converted)
+			obj = converted
+		default:
+			return fmt.Errorf("unexpected type of value for key at %d=%s in %v: expected a map or a list, got %T", i, k, keys, next)
+		}
+	}
+
+	return nil
+}
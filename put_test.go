@@ -0,0 +1,247 @@
+package vals
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/kroonprins/vals/pkg/api"
+)
+
+func TestPut(t *testing.T) {
+	dir := t.TempDir()
+	doc := filepath.Join(dir, "secrets.yaml")
+	raw := filepath.Join(dir, "raw.txt")
+
+	if err := os.WriteFile(doc, []byte("kept: value\ndb:\n  user: app\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	runtime, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	// The document is cached by the runtime, and evicted when the values are put
+	if _, err := runtime.Eval(map[string]interface{}{"kept": fmt.Sprintf("ref+file://%s#/kept", doc)}); err != nil {
+		t.Fatal(err)
+	}
+
+	puts := map[string]string{
+		fmt.Sprintf("ref+file://%s#/db/password", doc):             "s3cr3t",
+		fmt.Sprintf("file://%s#/api/token", doc):                   "t0ken",
+		fmt.Sprintf("ref+file://%s", raw):                          "raw value",
+		fmt.Sprintf("ref+file://%s#/new", filepath.Join(dir, "n")): "created",
+	}
+	for ref, value := range puts {
+		if err := runtime.Put(ctx, ref, value); err != nil {
+			t.Fatalf("unexpected error for %s: %v", ref, err)
+		}
+	}
+
+	actual, err := runtime.Eval(map[string]interface{}{
+		"password": fmt.Sprintf("ref+file://%s#/db/password", doc),
+		"token":    fmt.Sprintf("ref+file://%s#/api/token", doc),
+		"raw":      fmt.Sprintf("ref+file://%s", raw),
+		"created":  fmt.Sprintf("ref+file://%s#/new", filepath.Join(dir, "n")),
+		"kept":     fmt.Sprintf("ref+file://%s#/kept", doc),
+		"user":     fmt.Sprintf("ref+file://%s#/db/user", doc),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"password": "s3cr3t",
+		"token":    "t0ken",
+		"raw":      "raw value",
+		"created":  "created",
+		"kept":     "value",
+		"user":     "app",
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+	}
+}
+
+func TestPut_JSON(t *testing.T) {
+	dir := t.TempDir()
+
+	runtime, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// JSON files are kept in JSON, whether they are named *.json or only contain JSON
+	for _, name := range []string{"config.json", "config"} {
+		f := filepath.Join(dir, name)
+		if err := os.WriteFile(f, []byte(`{"db": {"user": "app"}}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := runtime.Put(context.Background(), fmt.Sprintf("ref+file://%s#/db/password", f), "s3cr3t"); err != nil {
+			t.Fatalf("unexpected error for %s: %v", name, err)
+		}
+
+		bs, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual map[string]interface{}
+		if err := json.Unmarshal(bs, &actual); err != nil {
+			t.Fatalf("%s isn't JSON anymore: %v\n%s", name, err, bs)
+		}
+
+		expected := map[string]interface{}{"db": map[string]interface{}{"user": "app", "password": "s3cr3t"}}
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Errorf("unexpected content of %s: -(expected), +(got)\n%s", name, diff)
+		}
+	}
+}
+
+func TestPut_ListIndex(t *testing.T) {
+	doc := filepath.Join(t.TempDir(), "servers.yaml")
+	if err := os.WriteFile(doc, []byte("servers:\n- host: a\n  port: 80\n- host: b\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	runtime, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	for ref, value := range map[string]string{
+		fmt.Sprintf("ref+file://%s#/servers/0/host", doc):   "c",
+		fmt.Sprintf("ref+file://%s#/servers/1/tls/ca", doc): "ca.pem",
+		fmt.Sprintf("ref+file://%s#/servers/1/port", doc):   "443",
+	} {
+		if err := runtime.Put(ctx, ref, value); err != nil {
+			t.Fatalf("unexpected error for %s: %v", ref, err)
+		}
+	}
+
+	actual, err := runtime.Eval(map[string]interface{}{
+		"first":  fmt.Sprintf("ref+file://%s#/servers/0/host", doc),
+		"port":   fmt.Sprintf("ref+file://%s#/servers/0/port", doc),
+		"second": fmt.Sprintf("ref+file://%s#/servers/1/host", doc),
+		"ca":     fmt.Sprintf("ref+file://%s#/servers/1/tls/ca", doc),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{"first": "c", "port": 80, "second": "b", "ca": "ca.pem"}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+	}
+
+	for ref, expected := range map[string]string{
+		fmt.Sprintf("ref+file://%s#/servers/2/host", doc): "index at 1=2 in [servers 2 host] is out of range: the list has 2 items",
+		fmt.Sprintf("ref+file://%s#/servers/x/host", doc): "unexpected key at 1=x in [servers x host]: expected an index of a list",
+	} {
+		if err := runtime.Put(ctx, ref, "d"); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("unexpected error for %s: expected to contain %q, got %v", ref, expected, err)
+		}
+	}
+}
+
+func TestPut_Errors(t *testing.T) {
+	runtime, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		ref string
+		err string
+	}{
+		{ref: "ref+echo://foo", err: "the provider for echo doesn't support writing values"},
+		{ref: "ref+file:///tmp/a||file:///tmp/b", err: "fallbacks can't be used with put"},
+		{ref: "ref+file:///tmp/a|b64enc", err: "transforms can't be used with put"},
+		{ref: "ref+file:///tmp/a?default=x", err: "default can't be used with put"},
+		{ref: "ref+file:///tmp/a#jmespath=foo", err: "jmespath expressions can't be used with put"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.ref, func(t *testing.T) {
+			err := runtime.Put(context.Background(), tc.ref, "value")
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("unexpected error: expected %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+// pathPerKeyProvider stores the values at their own paths, like the parameters of awsssm,
+// and blocks until the context is done when storing at `block`
+type pathPerKeyProvider struct {
+	failingProvider
+	set map[string]string
+}
+
+func (p *pathPerKeyProvider) SetString(key string, value string) error {
+	return p.SetStringContext(context.Background(), key, value)
+}
+
+func (p *pathPerKeyProvider) SetStringContext(ctx context.Context, key string, value string) error {
+	if key == "block" {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	p.set[key] = value
+	return nil
+}
+
+func (p *pathPerKeyProvider) SetStringMap(key string, m map[string]interface{}) error {
+	return p.SetStringMapContext(context.Background(), key, m)
+}
+
+func (p *pathPerKeyProvider) SetStringMapContext(ctx context.Context, key string, m map[string]interface{}) error {
+	return errors.New("unexpected write of the whole map")
+}
+
+func (p *pathPerKeyProvider) IsPathPerKey(key string) bool {
+	return true
+}
+
+var testPathPerKey = &pathPerKeyProvider{set: map[string]string{}}
+
+func init() {
+	RegisterProvider("testpathperkey", func(cfg api.StaticConfig) (api.Provider, error) {
+		return testPathPerKey, nil
+	})
+}
+
+func TestPut_PathPerKey(t *testing.T) {
+	runtime, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runtime.Put(context.Background(), "ref+testpathperkey://app/config#/db/password", "s3cr3t"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{"app/config/db/password": "s3cr3t"}
+	if diff := cmp.Diff(expected, testPathPerKey.set); diff != "" {
+		t.Errorf("unexpected values: -(expected), +(got)\n%s", diff)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := runtime.Put(ctx, "ref+testpathperkey://block", "value"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error: expected the deadline to be exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+package vals
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kroonprins/vals/pkg/expansion"
+)
+
+// minRedactedLength is the minimum length of the values that are redacted where they appear within longer strings.
+// Shorter values, like `true` and `80`, are redacted only where they make up whole values.
+const minRedactedLength = 6
+
+// Redactor replaces the values obtained for refs with placeholders like `<redacted:1a2b3c4d>`,
+// made of the prefix of the SHA-256 hash of the value, so that the output can be shared without leaking secrets
+// while still telling whether the values changed.
+//
+// Set it to Options.Redactor to record the values obtained while evaluating,
+// and write the output to the writer returned by Writer.
+// Values shorter than 6 characters are redacted only where they make up whole values.
+type Redactor struct {
+	// Only is the kinds of refs whose values are redacted, like `secretref`.
+	// The values of all the refs are redacted when empty.
+	Only []string
+
+	mu     sync.RWMutex
+	values map[string]struct{}
+	// sorted is the values sorted from the longest to the shortest, so that longer values are replaced first
+	sorted []string
+	// replacer replaces the values in sorted that are long enough, and is built again once values are added
+	replacer *strings.Replacer
+}
+
+// NewRedactor returns the Redactor for the values of the refs of the kinds, or of all the refs when no kind is given
+func NewRedactor(only ...string) *Redactor {
+	return &Redactor{
+		Only:   only,
+		values: map[string]struct{}{},
+	}
+}
+
+// Add records the value to be redacted. The items of maps and lists are recorded one by one.
+func (r *Redactor) Add(v interface{}) {
+	switch typed := v.(type) {
+	case nil:
+		return
+	case map[string]interface{}:
+		for _, v := range typed {
+			r.Add(v)
+		}
+		return
+	case map[interface{}]interface{}:
+		for _, v := range typed {
+			r.Add(v)
+		}
+		return
+	case []interface{}:
+		for _, v := range typed {
+			r.Add(v)
+		}
+		return
+	}
+	if v == expansion.Omit {
+		return
+	}
+
+	s := expansion.FormatScalar(v)
+	if s == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.values == nil {
+		r.values = map[string]struct{}{}
+	}
+	if _, ok := r.values[s]; ok {
+		return
+	}
+	r.values[s] = struct{}{}
+	r.sorted = append(r.sorted, s)
+	sort.SliceStable(r.sorted, func(i, j int) bool { return len(r.sorted[i]) > len(r.sorted[j]) })
+	r.replacer = nil
+}
+
+// addForKinds records the value obtained for a ref that appears with the kinds.
+// The value is recorded when the kinds are unknown, to be on the safe side.
+func (r *Redactor) addForKinds(v interface{}, kinds []string) {
+	if len(r.Only) == 0 || len(kinds) == 0 {
+		r.Add(v)
+		return
+	}
+	for _, k := range kinds {
+		for _, o := range r.Only {
+			if k == o {
+				r.Add(v)
+				return
+			}
+		}
+	}
+}
+
+// Redact replaces the recorded values in the string with their placeholders.
+// The values are replaced in a single pass, so that the placeholders are never replaced in turn.
+func (r *Redactor) Redact(s string) string {
+	r.mu.RLock()
+	_, whole := r.values[s]
+	replacer := r.replacer
+	r.mu.RUnlock()
+
+	if whole {
+		return redactedPlaceholder(s)
+	}
+	if replacer == nil {
+		replacer = r.buildReplacer()
+	}
+	return replacer.Replace(s)
+}
+
+// buildReplacer returns the replacer of the recorded values, which replaces the longest value where several match
+func (r *Redactor) buildReplacer() *strings.Replacer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.replacer == nil {
+		var oldnew []string
+		for _, v := range r.sorted {
+			if len(v) < minRedactedLength {
+				break
+			}
+			oldnew = append(oldnew, v, redactedPlaceholder(v))
+		}
+		r.replacer = strings.NewReplacer(oldnew...)
+	}
+	return r.replacer
+}
+
+// RedactValue returns the copy of the value whose keys and scalars are redacted.
+// Scalars like numbers and booleans are replaced with the placeholder strings when they were recorded.
+func (r *Redactor) RedactValue(v interface{}) interface{} {
+	switch typed := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return r.Redact(typed)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			m[r.Redact(k)] = r.RedactValue(v)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			m[r.Redact(fmt.Sprintf("%v", k))] = r.RedactValue(v)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(typed))
+		for i, v := range typed {
+			a[i] = r.RedactValue(v)
+		}
+		return a
+	default:
+		s := expansion.FormatScalar(typed)
+		if redacted := r.Redact(s); redacted != s {
+			return redacted
+		}
+		return v
+	}
+}
+
+// redactNode returns the copy of the YAML node whose scalars are redacted, keeping the comments and the styles
+func (r *Redactor) redactNode(n *yaml.Node) *yaml.Node {
+	c := *n
+	if n.Kind == yaml.ScalarNode {
+		if redacted := r.Redact(n.Value); redacted != n.Value {
+			c.Value = redacted
+			c.Tag = "!!str"
+			if c.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+				c.Style = 0
+			}
+		}
+		return &c
+	}
+	if n.Kind == yaml.AliasNode {
+		// Aliases refer to the anchored nodes, which are redacted on their own
+		return &c
+	}
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, child := range n.Content {
+		c.Content[i] = r.redactNode(child)
+	}
+	return &c
+}
+
+// Writer returns the writer that redacts the recorded values in what is written to w.
+// Output and OutputNodes redact the values as a whole before encoding them when given the writer,
+// so that values like multi-line certificates are redacted regardless of how they are encoded.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return &redactingWriter{w: w, r: r}
+}
+
+type redactingWriter struct {
+	w io.Writer
+	r *Redactor
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.r.Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactedPlaceholder returns the placeholder for the value, like `<redacted:1a2b3c4d>`
+func redactedPlaceholder(v string) string {
+	return fmt.Sprintf("<redacted:%x>", sha256.Sum256([]byte(v)))[:len("<redacted:")+8] + ">"
+}
+
+// describeValue describes the value in error messages, which contains the value itself unless hidden
+func describeValue(v interface{}, hide bool) string {
+	if hide {
+		return fmt.Sprintf("%s(%T)", redactedPlaceholder(fmt.Sprintf("%v", v)), v)
+	}
+	return fmt.Sprintf("%v(%T)", v, v)
+}
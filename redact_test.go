@@ -0,0 +1,142 @@
+package vals
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRedactor(t *testing.T) {
+	r := NewRedactor()
+	r.Add("s3cr3t-password")
+	r.Add(map[string]interface{}{"port": 5432, "list": []interface{}{"abc"}})
+	r.Add(nil)
+
+	pw := redactedPlaceholder("s3cr3t-password")
+	if !strings.HasPrefix(pw, "<redacted:") || len(pw) != len("<redacted:12345678>") {
+		t.Fatalf("unexpected placeholder: %s", pw)
+	}
+
+	testcases := []struct {
+		input    interface{}
+		expected interface{}
+	}{
+		{input: "s3cr3t-password", expected: pw},
+		{input: "postgres://user:s3cr3t-password@db", expected: "postgres://user:" + pw + "@db"},
+		{input: 5432, expected: redactedPlaceholder("5432")},
+		{input: "abc", expected: redactedPlaceholder("abc")},
+		// Short values are redacted only as whole values
+		{input: "abcdef", expected: "abcdef"},
+		{input: 80, expected: 80},
+		{input: "-----BEGIN-----\ns3cr3t-password\n-----END-----\n", expected: "-----BEGIN-----\n" + pw + "\n-----END-----\n"},
+		{
+			input:    map[string]interface{}{"s3cr3t-password": []interface{}{"abc", true}},
+			expected: map[string]interface{}{pw: []interface{}{redactedPlaceholder("abc"), true}},
+		},
+	}
+
+	for _, tc := range testcases {
+		if diff := cmp.Diff(tc.expected, r.RedactValue(tc.input)); diff != "" {
+			t.Errorf("unexpected result for %v: -(expected), +(got)\n%s", tc.input, diff)
+		}
+	}
+}
+
+func TestRedactor_Placeholders(t *testing.T) {
+	pw := redactedPlaceholder("s3cr3t-password")
+	hex := pw[len("<redacted:") : len(pw)-1]
+
+	// The shorter values that appear in the placeholders of the longer ones leave the placeholders intact
+	r := NewRedactor()
+	r.Add("s3cr3t-password")
+	r.Add("redacted")
+	r.Add(hex[:minRedactedLength])
+
+	input := "user:s3cr3t-password, redacted, " + hex[:minRedactedLength]
+	expected := "user:" + pw + ", " + redactedPlaceholder("redacted") + ", " + redactedPlaceholder(hex[:minRedactedLength])
+	if actual := r.Redact(input); actual != expected {
+		t.Errorf("unexpected result: expected=%q, got=%q", expected, actual)
+	}
+}
+
+func TestEvalNodes_Redact(t *testing.T) {
+	input := `plain: ref+echo://not-a-secret
+secret: secretref+echo://s3cr3t-password
+url: https://user:secretref+echo://s3cr3t-password+@example.com # comment
+`
+
+	for _, preserve := range []bool{false, true} {
+		nodes, err := nodesFromReader(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		redactor := NewRedactor("secretref")
+
+		runtime, err := New(Options{Redactor: redactor})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if preserve {
+			if err := runtime.EvalNodesInPlace(context.Background(), nodes); err != nil {
+				t.Fatal(err)
+			}
+			if err := OutputNodes(redactor.Writer(&buf), "yaml", nodes); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			res, err := runtime.EvalNodes(context.Background(), nodes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Output(redactor.Writer(&buf), "yaml", res); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		pw := redactedPlaceholder("s3cr3t-password")
+		expected := "plain: not-a-secret\nsecret: " + pw + "\nurl: https://user:" + pw + "@example.com\n"
+		if preserve {
+			expected = "plain: not-a-secret\nsecret: " + pw + "\nurl: https://user:" + pw + "@example.com # comment\n"
+		}
+		if diff := cmp.Diff(expected, buf.String()); diff != "" {
+			t.Errorf("unexpected output (preserve-format=%v): -(expected), +(got)\n%s", preserve, diff)
+		}
+
+		// The writer redacts what is written to it as is, too
+		buf.Reset()
+		if _, err := redactor.Writer(&buf).Write([]byte("echo s3cr3t-password")); err != nil {
+			t.Fatal(err)
+		}
+		if got, expected := buf.String(), "echo "+redactedPlaceholder("s3cr3t-password"); got != expected {
+			t.Errorf("unexpected output: expected %q, got %q", expected, got)
+		}
+	}
+}
+
+func TestEval_RedactErrors(t *testing.T) {
+	for _, redact := range []bool{false, true} {
+		runtime, err := New(Options{RedactErrors: redact})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = runtime.Eval(map[string]interface{}{"v": "ref+testmap://doc#/a/b"})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		var refErr *RefError
+		if !errors.As(err, &refErr) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if leaked := strings.Contains(err.Error(), "1(string)"); leaked == redact {
+			t.Errorf("unexpected error (RedactErrors=%v): %v", redact, err)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package vals
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kroonprins/vals/pkg/expansion"
+	"github.com/kroonprins/vals/pkg/transforms"
+)
+
+// Ref is a ref found by ExtractRefs
+type Ref struct {
+	// Kind is either `ref` or `secretref`
+	Kind string `json:"kind"`
+	// URI is the ref without the kind prefix, like `vault://foo/bar?address=https://vault:8200#/baz`.
+	// Each ref of a fallback chain like `vault://a||awsssm://b` is returned separately.
+	URI string `json:"uri"`
+	// Scheme is the scheme of the provider, like `vault`
+	Scheme string `json:"scheme"`
+	// Path is the path passed to the provider, like `foo/bar`
+	Path string `json:"path"`
+	// Fragment is the path to the value in the document returned by the provider, like `/baz`
+	Fragment string `json:"fragment,omitempty"`
+	// Query is the parameters of the ref, like `address`
+	Query url.Values `json:"query,omitempty"`
+	// Transforms is the transforms applied to the value, like `b64dec` and `indent 4`
+	Transforms []string `json:"transforms,omitempty"`
+	// Optional is true for refs that are marked with `?` like `ref?+vault://foo/bar`, or have the `optional` query parameter
+	Optional bool `json:"optional,omitempty"`
+	// Fallback is the position of the ref in the chain of fallbacks like `vault://a||awsssm://b`, which is 0 for the first ref
+	Fallback int `json:"fallback,omitempty"`
+
+	// Document is the index of the document that contains the ref
+	Document int `json:"document"`
+	// YAMLPath is the YAML path to the value or the key that contains the ref, like `foo.bar[0]`
+	YAMLPath string `json:"yamlPath"`
+	// Line and Column are the 1-based position in the input of the value or the key that contains the ref
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// ExtractRefs returns all the refs in the keys and the string values of the YAML documents in the order of appearance,
+// without getting their values from the providers.
+// Nested refs are returned before the refs that contain them, at the same locations.
+func ExtractRefs(nodes []yaml.Node) ([]Ref, error) {
+	expand := expansion.ExpandRegexMatch{
+		Target: expansion.DefaultRefRegexp,
+	}
+
+	var refs []Ref
+
+	var extract func(loc refLocation) error
+	extract = func(loc refLocation) error {
+		for fallback, source := range splitFallbacks(loc.Ref) {
+			if _, ok := parseLiteral(source); ok {
+				continue
+			}
+
+			refErr := func(err error) error {
+				return &RefError{
+					Document: loc.Document,
+					Path:     loc.Path,
+					Line:     loc.Line,
+					Column:   loc.Column,
+					Ref:      loc.Ref,
+					Err:      err,
+				}
+			}
+
+			// The nested refs are replaced with placeholders, which may not be valid in every part of a URI,
+			// and the placeholders are restored after parsing the URI
+			var nested []string
+			withPlaceholders, err := expansion.ReplaceNested(source, func(inner string) (string, error) {
+				for _, m := range expand.Matches(inner) {
+					innerLoc := loc
+					innerLoc.Kind, innerLoc.Ref, innerLoc.Optional = m.Kind, m.Ref, m.Optional
+					if err := extract(innerLoc); err != nil {
+						return "", err
+					}
+				}
+				nested = append(nested, inner)
+				return fmt.Sprintf("vals-nested-%d", len(nested)-1), nil
+			})
+			if err != nil {
+				if _, ok := err.(*RefError); ok {
+					return err
+				}
+				return refErr(err)
+			}
+			restore := func(s string) string {
+				for i := len(nested) - 1; i >= 0; i-- {
+					s = strings.ReplaceAll(s, fmt.Sprintf("vals-nested-%d", i), "{{"+nested[i]+"}}")
+				}
+				return s
+			}
+
+			ref, calls := transforms.Split(withPlaceholders)
+
+			var ts []string
+			for _, c := range calls {
+				ts = append(ts, restore(strings.Join(append([]string{c.Name}, c.Args...), " ")))
+			}
+
+			uri, err := url.Parse(ref)
+			if err != nil {
+				return refErr(err)
+			}
+
+			query := uri.Query()
+			for _, vs := range query {
+				for i := range vs {
+					vs[i] = restore(vs[i])
+				}
+			}
+
+			refs = append(refs, Ref{
+				Kind:       loc.Kind,
+				URI:        source,
+				Scheme:     uri.Scheme,
+				Path:       restore(refPath(uri)),
+				Fragment:   restore(uri.Fragment),
+				Query:      query,
+				Transforms: ts,
+				Optional:   loc.Optional || query.Get(ParamOptional) != "",
+				Fallback:   fallback,
+				Document:   loc.Document,
+				YAMLPath:   loc.Path,
+				Line:       loc.Line,
+				Column:     loc.Column,
+			})
+		}
+
+		return nil
+	}
+
+	for i := range nodes {
+		for _, loc := range locateRefsInNode(&expand, i, &nodes[i]) {
+			if err := extract(loc); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+// refLocation is where a ref appears in the evaluated documents
+type refLocation struct {
+	Document int
+	Path     string
+	Line     int
+	Column   int
+	Kind     string
+	Ref      string
+	Optional bool
+}
+
+// locateRefsInNode returns the locations of the refs in the keys and the string values of the YAML document,
+// in the order of appearance
+func locateRefsInNode(expand *expansion.ExpandRegexMatch, doc int, node *yaml.Node) []refLocation {
+	var locs []refLocation
+
+	add := func(path string, n *yaml.Node) {
+		for _, m := range expand.Matches(n.Value) {
+			locs = append(locs, refLocation{Document: doc, Path: path, Line: n.Line, Column: n.Column, Kind: m.Kind, Ref: m.Ref, Optional: m.Optional})
+		}
+	}
+
+	var walk func(path string, n *yaml.Node)
+	walk = func(path string, n *yaml.Node) {
+		switch n.Kind {
+		case yaml.DocumentNode:
+			for _, c := range n.Content {
+				walk(path, c)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				k, v := n.Content[i], n.Content[i+1]
+				p := joinPath(path, k.Value)
+				add(p, k)
+				walk(p, v)
+			}
+		case yaml.SequenceNode:
+			for i, c := range n.Content {
+				walk(fmt.Sprintf("%s[%d]", path, i), c)
+			}
+		case yaml.ScalarNode:
+			add(path, n)
+		}
+		// Aliases are skipped as the refs are already located at their anchors
+	}
+
+	walk("", node)
+
+	return locs
+}
+
+// locateRefsInMap is locateRefsInNode for documents that are already decoded.
+// The keys of maps are visited in the sorted order, and the locations have no line and column.
+func locateRefsInMap(expand *expansion.ExpandRegexMatch, m map[string]interface{}) []refLocation {
+	var locs []refLocation
+
+	add := func(path, s string) {
+		for _, m := range expand.Matches(s) {
+			locs = append(locs, refLocation{Path: path, Kind: m.Kind, Ref: m.Ref, Optional: m.Optional})
+		}
+	}
+
+	var walk func(path string, v interface{})
+	walkMap := func(path string, m map[string]interface{}) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := joinPath(path, k)
+			add(p, k)
+			walk(p, m[k])
+		}
+	}
+	walk = func(path string, v interface{}) {
+		switch typed := v.(type) {
+		case string:
+			add(path, typed)
+		case map[string]interface{}:
+			walkMap(path, typed)
+		case map[interface{}]interface{}:
+			converted := make(map[string]interface{}, len(typed))
+			for k, v := range typed {
+				converted[fmt.Sprintf("%v", k)] = v
+			}
+			walkMap(path, converted)
+		case []interface{}:
+			for i, v := range typed {
+				walk(fmt.Sprintf("%s[%d]", path, i), v)
+			}
+		case []string:
+			for i, v := range typed {
+				walk(fmt.Sprintf("%s[%d]", path, i), v)
+			}
+		}
+	}
+
+	walk("", m)
+
+	return locs
+}
+
+var plainPathKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// joinPath appends the key to the YAML path, quoting the key unless it consists only of safe characters
+func joinPath(path, key string) string {
+	if !plainPathKey.MatchString(key) {
+		return fmt.Sprintf("%s[%s]", path, strconv.Quote(key))
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
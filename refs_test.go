@@ -0,0 +1,131 @@
+package vals
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExtractRefs(t *testing.T) {
+	input := `foo: ref+vault://srv/foo/bar?address=https://vault:8200#/baz
+list:
+- plain
+- secretref+awsssm://path/to/param?region=us-east-1+-ref+echo://x
+---
+ref+file://foo.yaml: value
+`
+
+	nodes, err := nodesFromReader(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ExtractRefs(nodes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Ref{
+		{
+			Kind:     "ref",
+			URI:      "vault://srv/foo/bar?address=https://vault:8200#/baz",
+			Scheme:   "vault",
+			Path:     "srv/foo/bar",
+			Fragment: "/baz",
+			Query:    url.Values{"address": []string{"https://vault:8200"}},
+			Document: 0,
+			YAMLPath: "foo",
+			Line:     1,
+			Column:   6,
+		},
+		{
+			Kind:     "secretref",
+			URI:      "awsssm://path/to/param?region=us-east-1",
+			Scheme:   "awsssm",
+			Path:     "path/to/param",
+			Query:    url.Values{"region": []string{"us-east-1"}},
+			Document: 0,
+			YAMLPath: "list[1]",
+			Line:     4,
+			Column:   3,
+		},
+		{
+			Kind:     "ref",
+			URI:      "echo://x",
+			Scheme:   "echo",
+			Path:     "x",
+			Query:    url.Values{},
+			Document: 0,
+			YAMLPath: "list[1]",
+			Line:     4,
+			Column:   3,
+		},
+		{
+			Kind:     "ref",
+			URI:      "file://foo.yaml",
+			Scheme:   "file",
+			Path:     "foo.yaml",
+			Query:    url.Values{},
+			Document: 1,
+			YAMLPath: `["ref+file://foo.yaml"]`,
+			Line:     6,
+			Column:   1,
+		},
+	}
+
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+	}
+}
+
+func TestExtractRefs_Fallbacks(t *testing.T) {
+	nodes, err := nodesFromReader(strings.NewReader(`level: ref+vault://app#/level||awsssm://app/level?default=info||"debug"` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := ExtractRefs(nodes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var actual []string
+	for _, r := range refs {
+		actual = append(actual, fmt.Sprintf("%d %s %s", r.Fallback, r.Scheme, r.Path))
+	}
+
+	expected := []string{"0 vault app", "1 awsssm app/level"}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+	}
+}
+
+func TestExtractRefs_Nested(t *testing.T) {
+	nodes, err := nodesFromReader(strings.NewReader("pw: ref+vault://{{ref+awsssm://vault/host}}/secret/{{ref+awsssm://team/path}}?address={{ref+echo://addr}}#/pw\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := ExtractRefs(nodes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var actual []string
+	for _, r := range refs {
+		actual = append(actual, fmt.Sprintf("%s %s %s %s", r.Scheme, r.Path, r.Query.Get("address"), r.Fragment))
+	}
+
+	expected := []string{
+		"awsssm vault/host  ",
+		"awsssm team/path  ",
+		"echo addr  ",
+		"vault {{ref+awsssm://vault/host}}/secret/{{ref+awsssm://team/path}} {{ref+echo://addr}} /pw",
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+	}
+}
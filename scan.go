@@ -0,0 +1,227 @@
+package vals
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kroonprins/vals/pkg/expansion"
+)
+
+// Finding is a plaintext value that looks like a secret, found by ScanSecrets
+type Finding struct {
+	// Document is the index of the document that contains the value
+	Document int `json:"document"`
+	// Path is the YAML path to the value, like `db.password`
+	Path string `json:"path"`
+	// Keys is the keys and the list indices along Path, like `db` and `password`
+	Keys []string `json:"keys"`
+	// Line and Column are the 1-based position of the value in the input
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	// Reason is why the value looks like a secret, like `key name` or `AWS access key ID`
+	Reason string `json:"reason"`
+
+	node *yaml.Node
+}
+
+// secretFormats are the formats of well-known tokens and credentials
+var secretFormats = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{name: "private key", re: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY( BLOCK)?-----`)},
+	{name: "AWS access key ID", re: regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{name: "GitHub token", re: regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{name: "GitLab token", re: regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`)},
+	{name: "Slack token", re: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{name: "Google API key", re: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{name: "Stripe key", re: regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{16,}\b`)},
+	{name: "JSON web token", re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]+`)},
+	{name: "credentials in URL", re: regexp.MustCompile(`[a-z][a-z0-9+.-]*://[^/\s:@]+:[^/\s:@]+@`)},
+}
+
+// secretKeyName matches the keys whose values are likely secrets
+var secretKeyName = regexp.MustCompile(`(?i)(passw(or)?d|passphrase|pwd|secret|token|api[_-]?key|access[_-]?key|private[_-]?key|credential|auth)`)
+
+// notSecretKeyName matches the keys that match secretKeyName but whose values are usually not secrets,
+// like `tokenUrl` and `passwordMinLength`
+var notSecretKeyName = regexp.MustCompile(`(?i)(url|uri|endpoint|path|file|name|type|enabled|length|ttl|expir|policy|ref|id)$`)
+
+const (
+	// minEntropyLength is the minimum length of the values that are checked for the entropy
+	minEntropyLength = 20
+	// minEntropy is the Shannon entropy in bits per character above which values look random, like generated secrets
+	minEntropy = 4.0
+)
+
+// ScanSecrets returns the plaintext string values in the YAML documents that look like secrets,
+// in the order of appearance. Values that contain refs are skipped.
+//
+// Values are detected by the formats of well-known tokens, by the names of their keys like `password`,
+// and by their entropy.
+func ScanSecrets(nodes []yaml.Node) []Finding {
+	expand := expansion.ExpandRegexMatch{
+		Target: expansion.DefaultRefRegexp,
+	}
+
+	var findings []Finding
+
+	var walk func(doc int, keys []string, n *yaml.Node)
+	walk = func(doc int, keys []string, n *yaml.Node) {
+		switch n.Kind {
+		case yaml.DocumentNode:
+			for _, c := range n.Content {
+				walk(doc, keys, c)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				walk(doc, append(keys[:len(keys):len(keys)], n.Content[i].Value), n.Content[i+1])
+			}
+		case yaml.SequenceNode:
+			for i, c := range n.Content {
+				walk(doc, append(keys[:len(keys):len(keys)], strconv.Itoa(i)), c)
+			}
+		case yaml.ScalarNode:
+			if n.Tag != "!!str" || len(expand.Matches(n.Value)) > 0 {
+				return
+			}
+			var key string
+			if len(keys) > 0 {
+				key = keys[len(keys)-1]
+			}
+			if reason := secretReason(key, n.Value); reason != "" {
+				findings = append(findings, Finding{
+					Document: doc,
+					Path:     yamlPath(keys),
+					Keys:     keys,
+					Line:     n.Line,
+					Column:   n.Column,
+					Reason:   reason,
+					node:     n,
+				})
+			}
+		}
+		// Aliases are skipped as the values are found at their anchors
+	}
+
+	for i := range nodes {
+		walk(i, nil, &nodes[i])
+	}
+
+	return findings
+}
+
+// secretReason returns why the value for the key looks like a secret, or an empty string if it doesn't
+func secretReason(key, value string) string {
+	for _, f := range secretFormats {
+		if f.re.MatchString(value) {
+			return f.name
+		}
+	}
+
+	trimmed := strings.TrimSpace(value)
+
+	if _, err := strconv.Atoi(key); err != nil && secretKeyName.MatchString(key) && !notSecretKeyName.MatchString(key) {
+		switch strings.ToLower(trimmed) {
+		case "", "true", "false", "null", "none", "changeme", "<nil>":
+		default:
+			return "key name"
+		}
+	}
+
+	if len(trimmed) >= minEntropyLength && strings.IndexFunc(trimmed, unicode.IsSpace) < 0 {
+		if e := entropy(trimmed); e >= minEntropy {
+			return fmt.Sprintf("entropy %.2f", e)
+		}
+	}
+
+	return ""
+}
+
+// entropy returns the Shannon entropy of the string in bits per character
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	var n int
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+
+	var e float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+// yamlPath returns the YAML path like `db.password` and `hosts[0]` for the keys and the list indices
+func yamlPath(keys []string) string {
+	var path string
+	for _, k := range keys {
+		if _, err := strconv.Atoi(k); err == nil {
+			path = fmt.Sprintf("%s[%s]", path, k)
+			continue
+		}
+		path = joinPath(path, k)
+	}
+	return path
+}
+
+// WriteSecretsTo stores the values of the findings in the backend of the base ref, like `ref+vault://secret/app`,
+// and replaces the values in the YAML nodes the findings were found in with the refs to the stored values.
+//
+// Each value is stored at the fragment made of the keys along its path, like `ref+vault://secret/app#/db/password`.
+// The keys are prefixed with the index of the document when multiDoc is set, so that the values of the documents don't collide.
+func (r *Runtime) WriteSecretsTo(ctx context.Context, findings []Finding, base string, multiDoc bool) error {
+	if ixs := expansion.DefaultRefRegexp.FindStringSubmatchIndex(base); ixs != nil && ixs[0] == 0 {
+		base = strings.TrimSuffix(base[ixs[6]:], "+")
+	}
+
+	base, frag, _ := strings.Cut(base, "#")
+	frag = strings.Trim(frag, "/")
+
+	for _, f := range findings {
+		if f.node == nil {
+			return fmt.Errorf("%s: the finding isn't returned by ScanSecrets", f.Path)
+		}
+
+		keys := f.Keys
+		if multiDoc {
+			keys = append([]string{strconv.Itoa(f.Document)}, keys...)
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("document %d: the value of the whole document can't be stored at a key", f.Document)
+		}
+		for _, k := range keys {
+			if k == "" || strings.ContainsAny(k, "/#+") {
+				return fmt.Errorf("%s: the key %q can't be used in ref fragments", f.Path, k)
+			}
+		}
+
+		components := keys
+		if frag != "" {
+			components = append([]string{frag}, keys...)
+		}
+		ref := base + "#/" + strings.Join(components, "/")
+
+		if err := r.Put(ctx, ref, f.node.Value); err != nil {
+			return fmt.Errorf("%s (document %d, line %d, column %d): %w", f.Path, f.Document, f.Line, f.Column, err)
+		}
+
+		f.node.Value = "ref+" + ref
+		f.node.Tag = "!!str"
+		if f.node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+			f.node.Style = 0
+		}
+	}
+
+	return nil
+}
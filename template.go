@@ -0,0 +1,201 @@
+package vals
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/kroonprins/vals/pkg/expansion"
+	"github.com/kroonprins/vals/pkg/transforms"
+)
+
+// RenderTemplate renders the Go text/template with the data, writing the result to w,
+// so that config files in any text format, like nginx.conf and .properties, can contain the values of refs.
+//
+// Besides the built-in functions of text/template, templates can call:
+//
+//   - `ref` and `secretref`, which return the value of the ref like `{{ ref "vault://secret/db#/password" }}`.
+//     The `ref+` prefix is optional, and refs can contain fallbacks, transforms and nested refs as in YAML.
+//     Optional refs like `{{ ref "ref?+vault://secret/db#/password" }}` return an empty string when the secret doesn't exist.
+//   - the transforms, like `{{ ref "vault://secret/db#/cert" | indent 4 }}`, whose arguments come before the value
+//   - the helpers `default`, `required`, `quote`, `squote`, `nindent`, `replace`, `trimPrefix`, `trimSuffix`,
+//     `contains`, `hasPrefix`, `hasSuffix`, `join`, `split`, `toJson`, `fromJson`, `toYaml` and `fromYaml`
+//
+// Values are looked up with the caches of the runtime, and recorded to Options.Redactor when set.
+// Missing keys in the data are errors.
+func (r *Runtime) RenderTemplate(ctx context.Context, w io.Writer, name, text string, data interface{}) error {
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(r.templateFuncs(ctx)).Parse(text)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+// RenderTemplate renders the Go text/template with the data like Runtime.RenderTemplate does, with a new runtime
+func RenderTemplate(ctx context.Context, w io.Writer, name, text string, data interface{}, o ...Options) error {
+	opts := Options{}
+	if len(o) > 0 {
+		opts = o[0]
+	}
+	runtime, err := New(opts)
+	if err != nil {
+		return err
+	}
+	return runtime.RenderTemplate(ctx, w, name, text, data)
+}
+
+func (r *Runtime) templateFuncs(ctx context.Context) template.FuncMap {
+	lookup := r.lookupFunc(ctx)
+
+	refFunc := func(kind string) func(string) (interface{}, error) {
+		expand := r.expander()
+		expand.Lookup = func(key string) (interface{}, error) {
+			v, err := lookup(key)
+			if err == nil && r.Options.Redactor != nil {
+				r.Options.Redactor.addForKinds(v, []string{kind})
+			}
+			return v, err
+		}
+
+		return func(ref string) (interface{}, error) {
+			if ixs := expansion.DefaultRefRegexp.FindStringSubmatchIndex(ref); ixs == nil || ixs[0] != 0 {
+				ref = kind + "+" + ref
+			}
+			if !expansion.DefaultRefRegexp.MatchString(ref) {
+				return nil, fmt.Errorf("invalid ref %q: expected a ref like \"vault://secret/db#/password\"", ref)
+			}
+			v, err := expand.InString(ref)
+			if err != nil {
+				return nil, err
+			}
+			if v == expansion.Omit {
+				return "", nil
+			}
+			return v, nil
+		}
+	}
+
+	funcs := template.FuncMap{
+		"default":    templateDefault,
+		"required":   templateRequired,
+		"quote":      func(v interface{}) string { return fmt.Sprintf("%q", templateString(v)) },
+		"squote":     func(v interface{}) string { return "'" + templateString(v) + "'" },
+		"nindent":    templateNindent,
+		"replace":    func(old, new string, v interface{}) string { return strings.ReplaceAll(templateString(v), old, new) },
+		"trimPrefix": func(prefix string, v interface{}) string { return strings.TrimPrefix(templateString(v), prefix) },
+		"trimSuffix": func(suffix string, v interface{}) string { return strings.TrimSuffix(templateString(v), suffix) },
+		"contains":   func(sub string, v interface{}) bool { return strings.Contains(templateString(v), sub) },
+		"hasPrefix":  func(prefix string, v interface{}) bool { return strings.HasPrefix(templateString(v), prefix) },
+		"hasSuffix":  func(suffix string, v interface{}) bool { return strings.HasSuffix(templateString(v), suffix) },
+		"join":       templateJoin,
+		"split":      func(sep string, v interface{}) []string { return strings.Split(templateString(v), sep) },
+		"toJson":     transformFunc("jsonenc"),
+		"fromJson":   transformFunc("jsondec"),
+		"toYaml":     transformFunc("yamlenc"),
+		"fromYaml":   transformFunc("yamldec"),
+	}
+
+	// Transforms registered with RegisterTransform take precedence over the helpers of the same names
+	for _, name := range transforms.Names() {
+		funcs[name] = transformFunc(name)
+	}
+
+	funcs["ref"] = refFunc("ref")
+	funcs["secretref"] = refFunc("secretref")
+
+	return funcs
+}
+
+// transformFunc returns the template function that calls the transform,
+// with the arguments of the transform followed by the value as in `{{ . | indent 4 }}`
+func transformFunc(name string) func(args ...interface{}) (interface{}, error) {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s: missing value", name)
+		}
+		f, ok := transforms.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("no transform registered for %q", name)
+		}
+		strs := make([]string, 0, len(args)-1)
+		for _, a := range args[:len(args)-1] {
+			strs = append(strs, templateString(a))
+		}
+		v, err := f(args[len(args)-1], strs...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return v, nil
+	}
+}
+
+// templateString returns the string for the value in templates, where scalars are formatted as they would be in YAML
+func templateString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return expansion.FormatScalar(v)
+}
+
+// templateEmpty returns true for nil, empty strings, maps and lists, false and zero
+func templateEmpty(v interface{}) bool {
+	switch typed := v.(type) {
+	case nil:
+		return true
+	case string:
+		return typed == ""
+	case bool:
+		return !typed
+	case int:
+		return typed == 0
+	case int64:
+		return typed == 0
+	case float64:
+		return typed == 0
+	case map[string]interface{}:
+		return len(typed) == 0
+	case map[interface{}]interface{}:
+		return len(typed) == 0
+	case []interface{}:
+		return len(typed) == 0
+	case []string:
+		return len(typed) == 0
+	}
+	return false
+}
+
+// templateDefault returns the default value when the value is empty or omitted, like `{{ ref "ref?+vault://secret/db#/user" | default "app" }}`
+func templateDefault(def interface{}, v ...interface{}) interface{} {
+	if len(v) == 0 || templateEmpty(v[0]) {
+		return def
+	}
+	return v[0]
+}
+
+func templateRequired(msg string, v interface{}) (interface{}, error) {
+	if templateEmpty(v) {
+		return nil, fmt.Errorf("%s", msg)
+	}
+	return v, nil
+}
+
+func templateNindent(n int, v interface{}) string {
+	pad := strings.Repeat(" ", n)
+	return "\n" + pad + strings.ReplaceAll(templateString(v), "\n", "\n"+pad)
+}
+
+func templateJoin(sep string, v interface{}) (string, error) {
+	switch typed := v.(type) {
+	case []string:
+		return strings.Join(typed, sep), nil
+	case []interface{}:
+		strs := make([]string, len(typed))
+		for i, item := range typed {
+			strs[i] = templateString(item)
+		}
+		return strings.Join(strs, sep), nil
+	}
+	return "", fmt.Errorf("join: unexpected type of value %T: expected a list", v)
+}
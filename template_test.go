@@ -0,0 +1,152 @@
+package vals
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	dir := t.TempDir()
+	doc := filepath.Join(dir, "secrets.yaml")
+	if err := os.WriteFile(doc, []byte("db:\n  password: s3cr3t\n  port: 5432\nhosts:\n- a\n- b\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name     string
+		template string
+		data     interface{}
+		expected string
+	}{
+		{
+			name:     "ref",
+			template: `password={{ ref "file://DOC#/db/password" }}`,
+			expected: "password=s3cr3t",
+		},
+		{
+			name:     "prefixed ref and secretref",
+			template: `{{ ref "ref+echo://foo/bar" }} {{ secretref "echo://baz" }} {{ secretref "secretref+echo://qux" }}`,
+			expected: "foo/bar baz qux",
+		},
+		{
+			name:     "scalar types",
+			template: `{{ $port := ref "file://DOC#/db/port" }}{{ if eq $port 5432 }}default port{{ end }}`,
+			expected: "default port",
+		},
+		{
+			name:     "map",
+			template: `{{ $db := ref "file://DOC#/db/*" }}{{ $db.password }}`,
+			expected: "s3cr3t",
+		},
+		{
+			name:     "transforms",
+			template: `{{ ref "file://DOC#/db/password" | b64enc }} {{ ref "echo://a|upper" }}{{ ref "echo://x" | indent 2 | nindent 2 }}`,
+			expected: "czNjcjN0 A\n    x",
+		},
+		{
+			name:     "helpers",
+			template: `{{ ref "echo://a/b" | quote }} {{ ref "echo://a/b" | squote }} {{ ref "echo://a/b" | replace "/" "." }} {{ ref "echo://a/b" | split "/" | join "," }} {{ ref "file://DOC#/db/*" | toJson }}`,
+			expected: `"a/b" 'a/b' a.b a,b {"password":"s3cr3t","port":5432}`,
+		},
+		{
+			name:     "optional",
+			template: `user={{ ref "ref?+file://DOC#/db/user" | default "app" }}`,
+			expected: "user=app",
+		},
+		{
+			name:     "fallback",
+			template: `user={{ ref "file://DOC#/db/user||file://DOC#/db/password" }}`,
+			expected: "user=s3cr3t",
+		},
+		{
+			name:     "nested",
+			template: `{{ ref "echo://{{ref+echo://inner}}/outer" }}`,
+			expected: "inner/outer",
+		},
+		{
+			name:     "data",
+			template: `{{ .name }}={{ ref "file://DOC#/db/password" }}`,
+			data:     map[string]interface{}{"name": "password"},
+			expected: "password=s3cr3t",
+		},
+	}
+
+	for i := range testcases {
+		tc := testcases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			runtime, err := New(Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := runtime.RenderTemplate(context.Background(), &buf, tc.name, strings.ReplaceAll(tc.template, "DOC", doc), tc.data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.expected, buf.String()); diff != "" {
+				t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRenderTemplate_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	testcases := []struct {
+		template string
+		expected string
+	}{
+		{
+			template: fmt.Sprintf(`{{ ref "file://%s/missing.yaml#/key" }}`, dir),
+			expected: "missing.yaml",
+		},
+		{
+			template: `{{ .missing }}`,
+			expected: `map has no entry for key "missing"`,
+		},
+		{
+			template: fmt.Sprintf(`{{ ref "ref?+file://%s/missing.yaml#/key" | required "the value is required" }}`, dir),
+			expected: "the value is required",
+		},
+		{
+			template: `{{ ref "echo://" }}`,
+			expected: "invalid ref",
+		},
+	}
+
+	for _, tc := range testcases {
+		runtime, err := New(Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		err = runtime.RenderTemplate(context.Background(), &buf, "test", tc.template, map[string]interface{}{})
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.template, tc.expected, err)
+		}
+	}
+}
+
+func TestRenderTemplate_Redactor(t *testing.T) {
+	redactor := NewRedactor("secretref")
+
+	var buf bytes.Buffer
+	err := RenderTemplate(context.Background(), &buf, "test", `{{ ref "echo://public-value" }} {{ secretref "echo://private-value" }}`, nil, Options{Redactor: redactor})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := redactor.Redact(buf.String()); got != "public-value "+redactedPlaceholder("private-value") {
+		t.Errorf("unexpected redacted output: %s", got)
+	}
+}
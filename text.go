@@ -0,0 +1,80 @@
+package vals
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kroonprins/vals/pkg/expansion"
+)
+
+// EvalText replaces the refs in the plain text, like the contents of .ini, .conf and .env files, with their values.
+//
+// The text is evaluated line by line, so a ref ends either at the `+` that follows it, like in `url=https://ref+echo://host+/path`,
+// or at the end of the line. Lines that contain optional refs whose secrets don't exist are removed,
+// and refs must evaluate to scalars, which are formatted as they would be in YAML.
+//
+// When a ref fails to evaluate, the returned error is an *EvalError that contains the line and the column of the ref.
+func (r *Runtime) EvalText(ctx context.Context, text string) (string, error) {
+	expand := r.expander()
+
+	lines := strings.SplitAfter(text, "\n")
+
+	var locations []refLocation
+	for i, line := range lines {
+		locations = append(locations, locateRefsInLine(&expand, i+1, line)...)
+	}
+
+	lookup, err := r.resolve(ctx, locations)
+	if err != nil {
+		return "", err
+	}
+	expand.Lookup = lookup
+
+	var sb strings.Builder
+	for i, line := range lines {
+		content := strings.TrimRight(line, "\r\n")
+		eol := line[len(content):]
+
+		v, err := expand.InString(content)
+		if err != nil {
+			return "", fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		switch typed := v.(type) {
+		case string:
+			sb.WriteString(typed)
+		case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+			return "", fmt.Errorf("line %d: unexpected type of value %T: refs in text must evaluate to scalars", i+1, v)
+		default:
+			if v == expansion.Omit {
+				continue
+			}
+			sb.WriteString(expansion.FormatScalar(typed))
+		}
+		sb.WriteString(eol)
+	}
+
+	return sb.String(), nil
+}
+
+// locateRefsInLine returns the locations of the refs in the line of plain text, with the 1-based columns of their `ref+` prefixes
+func locateRefsInLine(expand *expansion.ExpandRegexMatch, lineNum int, line string) []refLocation {
+	var locs []refLocation
+
+	var offset int
+	for _, m := range expand.Matches(strings.TrimRight(line, "\r\n")) {
+		column := 0
+		if i := strings.Index(line[offset:], m.Ref); i >= 0 {
+			start := strings.LastIndex(line[:offset+i], m.Kind)
+			if start < 0 {
+				start = offset + i
+			}
+			column = start + 1
+			offset += i + len(m.Ref)
+		}
+		locs = append(locs, refLocation{Line: lineNum, Column: column, Kind: m.Kind, Ref: m.Ref, Optional: m.Optional})
+	}
+
+	return locs
+}
@@ -1,37 +1,32 @@
 package vals
 
 import (
+	"context"
 	"crypto/md5"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/url"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/kroonprins/vals/pkg/config"
-	"github.com/kroonprins/vals/pkg/providers/googlesheets"
-	"github.com/kroonprins/vals/pkg/providers/s3"
+	"github.com/kroonprins/vals/pkg/diskcache"
 
 	lru "github.com/hashicorp/golang-lru"
+	"github.com/jmespath/go-jmespath"
 	"github.com/kroonprins/vals/pkg/api"
 	"github.com/kroonprins/vals/pkg/expansion"
-	"github.com/kroonprins/vals/pkg/providers/awskms"
-	"github.com/kroonprins/vals/pkg/providers/awssecrets"
-	"github.com/kroonprins/vals/pkg/providers/azurekeyvault"
-	"github.com/kroonprins/vals/pkg/providers/echo"
-	"github.com/kroonprins/vals/pkg/providers/envsubst"
-	"github.com/kroonprins/vals/pkg/providers/file"
-	"github.com/kroonprins/vals/pkg/providers/gcpsecrets"
-	"github.com/kroonprins/vals/pkg/providers/gcs"
-	"github.com/kroonprins/vals/pkg/providers/gitlab"
-	"github.com/kroonprins/vals/pkg/providers/sops"
-	"github.com/kroonprins/vals/pkg/providers/ssm"
-	"github.com/kroonprins/vals/pkg/providers/tfstate"
-	"github.com/kroonprins/vals/pkg/providers/vault"
+	"github.com/kroonprins/vals/pkg/providers"
 	"github.com/kroonprins/vals/pkg/stringmapprovider"
 	"github.com/kroonprins/vals/pkg/stringprovider"
+	"github.com/kroonprins/vals/pkg/transforms"
 	"gopkg.in/yaml.v3"
 )
 
@@ -55,9 +50,30 @@ const (
 	KeySet        = "set"
 	KeyValuesFrom = "valuesFrom"
 
+	// FragmentJMESPath is the prefix of ref URI fragments that are JMESPath expressions evaluated against the document
+	// returned by the provider, like `#jmespath=users[?role=='admin'].password | [0]`
+	FragmentJMESPath = "jmespath="
+
+	// ParamTimeout is the query parameter of a ref URI that limits the time spent on getting its value, e.g. `?timeout=5s`.
+	// It is consumed by vals and not passed to the provider.
+	ParamTimeout = "timeout"
+
+	// ParamDefault is the query parameter of a ref URI that gives the value used when the ref fails, e.g. `?default=info`.
+	// It is consumed by vals and not passed to the provider.
+	ParamDefault = "default"
+
+	// ParamOptional is the query parameter of a ref URI that makes the ref optional.
+	// When the secret doesn't exist, `?optional=true` removes the map entry or the list item that contains the ref,
+	// and `?optional=null` replaces the value with null.
+	// It is consumed by vals and not passed to the provider, except for the providers that define it themselves.
+	ParamOptional = "optional"
+
 	// secret cache size
 	defaultCacheSize = 512
 
+	// number of refs resolved in parallel
+	defaultConcurrency = 8
+
 	ProviderVault            = "vault"
 	ProviderS3               = "s3"
 	ProviderGCS              = "gcs"
@@ -79,10 +95,47 @@ const (
 	ProviderEnvSubst         = "envsubst"
 )
 
+// providerParams is the query parameters of vals that are passed to the providers of the schemes instead,
+// as the providers define parameters of the same names
+var providerParams = map[string][]string{
+	// `?optional=true` makes gcpsecrets return an empty value when it fails to get the secret
+	"gcpsecrets": {ParamOptional},
+}
+
+// isProviderParam reports whether the query parameter is defined by the provider of the scheme
+func isProviderParam(scheme, param string) bool {
+	for _, p := range providerParams[scheme] {
+		if p == param {
+			return true
+		}
+	}
+	return false
+}
+
 var (
 	EnvFallbackPrefix = "VALS_"
 )
 
+// ProviderFactory creates a provider from the query parameters of a ref URI,
+// or from the `provider` section of a config passed to Load.
+type ProviderFactory = providers.Factory
+
+// RegisterProvider makes the provider created by the factory available as `ref+<scheme>://`.
+// It is meant to be called from an init function of the package implementing the provider,
+// and panics when a provider is already registered for the scheme.
+func RegisterProvider(scheme string, factory ProviderFactory) {
+	providers.Register(scheme, factory)
+}
+
+// TransformFunc transforms the value of a ref, as in `ref+vault://secret/db#/password|b64dec|trim`
+type TransformFunc = transforms.Func
+
+// RegisterTransform makes the transform available as `|<name>` in refs.
+// It panics when a transform is already registered for the name, including the built-in ones like "b64dec".
+func RegisterTransform(name string, f TransformFunc) {
+	transforms.Register(name, f)
+}
+
 type Evaluator interface {
 	Eval(map[string]interface{}) (map[string]interface{}, error)
 }
@@ -90,8 +143,11 @@ type Evaluator interface {
 // Runtime an object for secrets rendering
 type Runtime struct {
 	providers map[string]api.Provider
-	docCache  *lru.Cache // secret documents are cached to improve performance
-	strCache  *lru.Cache // secrets are cached to improve performance
+	docCache  Cache // secret documents are cached to improve performance
+	strCache  Cache // secrets are cached to improve performance
+
+	// inflight deduplicates concurrent provider calls for the same secret or document
+	inflight inflight
 
 	Options Options
 
@@ -108,33 +164,221 @@ func New(opts Options) (*Runtime, error) {
 		providers: map[string]api.Provider{},
 		Options:   opts,
 	}
-	var err error
-	r.docCache, err = lru.New(cacheSize)
+	docCache, err := lru.New(cacheSize)
 	if err != nil {
 		return nil, err
 	}
-	r.strCache, err = lru.New(cacheSize)
+	r.docCache = newTieredCache(docCache, opts.DiskCache, "documents")
+	strCache, err := lru.New(cacheSize)
 	if err != nil {
 		return nil, err
 	}
+	r.strCache = newTieredCache(strCache, opts.DiskCache, "strings")
 	return r, nil
 }
 
+// Close closes the providers created by the runtime that hold resources, like the running executables of plugins,
+// and returns the first error. The providers are created again when the runtime is used afterwards.
+func (r *Runtime) Close() error {
+	r.m.Lock()
+	ps := r.providers
+	r.providers = map[string]api.Provider{}
+	r.m.Unlock()
+
+	var err error
+	for _, p := range ps {
+		c, ok := p.(io.Closer)
+		if !ok {
+			continue
+		}
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
 // Eval replaces 'ref+<provider>://xxxxx' entries by their actual values
 func (r *Runtime) Eval(template map[string]interface{}) (map[string]interface{}, error) {
-	var err error
+	return r.EvalContext(context.Background(), template)
+}
+
+// EvalContext is Eval that gives up getting values from providers once ctx is done.
+// When a ref fails to evaluate, the returned error is an *EvalError that locates the ref in the template.
+func (r *Runtime) EvalContext(ctx context.Context, template map[string]interface{}) (map[string]interface{}, error) {
+	expand := r.expander()
+
+	res, err := r.evalDocuments(ctx, []map[string]interface{}{template}, locateRefsInMap(&expand, template))
+	if err != nil {
+		return nil, err
+	}
+
+	return res[0], nil
+}
+
+// EvalNodes evaluates the YAML documents like EvalContext does.
+// Unlike EvalContext, the *EvalError returned on failures contains the index of the document,
+// and the line and the column of each ref that failed to evaluate.
+func (r *Runtime) EvalNodes(ctx context.Context, nodes []yaml.Node) ([]yaml.Node, error) {
+	expand := r.expander()
+
+	templates := make([]map[string]interface{}, len(nodes))
+	var locations []refLocation
+	for i := range nodes {
+		if err := nodes[i].Decode(&templates[i]); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		locations = append(locations, locateRefsInNode(&expand, i, &nodes[i])...)
+	}
+
+	res, err := r.evalDocuments(ctx, templates, locations)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]yaml.Node, len(res))
+	for i, m := range res {
+		if err := out[i].Encode(m); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+	}
+
+	return out, nil
+}
+
+func (r *Runtime) expander() expansion.ExpandRegexMatch {
+	var only []string
+	if r.Options.ExcludeSecret {
+		only = []string{"ref"}
+	}
+
+	return expansion.ExpandRegexMatch{
+		Only:   only,
+		Target: expansion.DefaultRefRegexp,
+	}
+}
+
+// evalDocuments looks up all the refs at the locations before expanding any of the templates,
+// so that the failures can be reported with their locations.
+func (r *Runtime) evalDocuments(ctx context.Context, templates []map[string]interface{}, locations []refLocation) ([]map[string]interface{}, error) {
+	lookup, err := r.resolve(ctx, locations)
+	if err != nil {
+		return nil, err
+	}
 
-	uriToProviderHash := func(uri *url.URL) string {
+	expand := r.expander()
+	expand.Lookup = lookup
+
+	ret := make([]map[string]interface{}, len(templates))
+	for i, template := range templates {
+		m, err := expand.InMap(template)
+		if err != nil {
+			if len(templates) > 1 {
+				return nil, fmt.Errorf("document %d: %w", i, err)
+			}
+			return nil, err
+		}
+		ret[i] = m
+	}
+
+	return ret, nil
+}
+
+// resolve looks up all the refs at the locations, and returns the lookup function that returns the results.
+// The returned *EvalError contains only the first failure unless Options.KeepGoing is set.
+func (r *Runtime) resolve(ctx context.Context, locations []refLocation) (func(string) (interface{}, error), error) {
+	lookup := r.lookupFunc(ctx)
+
+	nested := r.expander()
+	nested.Lookup = lookup
+
+	// The nested refs are resolved beforehand, so that the resulting refs are prefetched along with the others
+	keys := make(map[string]string, len(locations))
+	nestedErrs := map[string]error{}
+	refs := make([]string, 0, len(locations))
+	for _, loc := range locations {
+		key := loc.Ref
+		if expansion.HasNested(key) {
+			resolved, err := nested.ResolveNested(key)
+			if err != nil {
+				nestedErrs[loc.Ref] = err
+				continue
+			}
+			key = resolved
+		}
+		keys[loc.Ref] = key
+		refs = append(refs, key)
+	}
+
+	results := r.prefetch(refs, lookup)
+
+	var evalErr EvalError
+	for _, loc := range locations {
+		var res lookupResult
+		if err, ok := nestedErrs[loc.Ref]; ok {
+			res = lookupResult{err: err}
+		} else {
+			key := keys[loc.Ref]
+			var ok bool
+			res, ok = results[key]
+			if !ok {
+				val, err := lookup(key)
+				res = lookupResult{val: val, err: err}
+				results[key] = res
+			}
+		}
+		if res.err == nil || loc.Optional && errors.Is(res.err, api.ErrNotFound) {
+			continue
+		}
+		evalErr.Errors = append(evalErr.Errors, &RefError{
+			Document: loc.Document,
+			Path:     loc.Path,
+			Line:     loc.Line,
+			Column:   loc.Column,
+			Ref:      loc.Ref,
+			Err:      res.err,
+		})
+		if !r.Options.KeepGoing {
+			break
+		}
+	}
+
+	if len(evalErr.Errors) > 0 {
+		return nil, &evalErr
+	}
+
+	kinds := map[string][]string{}
+	for _, loc := range locations {
+		if key, ok := keys[loc.Ref]; ok {
+			kinds[key] = append(kinds[key], loc.Kind)
+		}
+	}
+
+	return func(key string) (interface{}, error) {
+		res, ok := results[key]
+		if !ok {
+			val, err := lookup(key)
+			res = lookupResult{val: val, err: err}
+		}
+		if res.err == nil && r.Options.Redactor != nil {
+			// The refs not located beforehand, like the ones in the values of other refs, are of unknown kinds
+			r.Options.Redactor.addForKinds(res.val, kinds[key])
+		}
+		return res.val, res.err
+	}, nil
+}
+
+// providerFor returns the provider for the scheme of the ref URI configured with the query parameters,
+// which is shared by all the refs with the same scheme and parameters
+func (r *Runtime) providerFor(uri *url.URL, query url.Values) (api.Provider, error) {
+	uriToProviderHash := func(scheme string, query url.Values) string {
 		bs := []byte{}
-		bs = append(bs, []byte(uri.Scheme)...)
-		query := uri.Query().Encode()
-		bs = append(bs, []byte(query)...)
+		bs = append(bs, []byte(scheme)...)
+		bs = append(bs, []byte(query.Encode())...)
 		return fmt.Sprintf("%x", md5.Sum(bs))
 	}
 
-	createProvider := func(scheme string, uri *url.URL) (api.Provider, error) {
-		query := uri.Query()
-
+	createProvider := func(scheme string, query url.Values) (api.Provider, error) {
 		m := map[string]interface{}{}
 		for key, params := range query {
 			if len(params) > 0 {
@@ -149,226 +393,383 @@ func (r *Runtime) Eval(template map[string]interface{}) (map[string]interface{},
 
 		conf := config.MapConfig{M: m, FallbackFunc: envFallback}
 
-		switch scheme {
-		case ProviderVault:
-			p := vault.New(conf)
-			return p, nil
-		case ProviderS3:
-			// ref+s3://foo/bar?region=ap-northeast-1#/baz
-			// 1. GetObject for the bucket foo and key bar
-			// 2. Then extracts the value for key baz(=/foo/bar/baz) from the result from step 1.
-			p := s3.New(conf)
-			return p, nil
-		case ProviderGCS:
-			// vals+gcs://foo/bar?generation=timestamp#/baz
-			// 1. GetObject for the bucket foo and key bar
-			// 2. Then extracts the value for key baz(=/foo/bar/baz) from the result from step 1.
-			p := gcs.New(conf)
-			return p, nil
-		case ProviderGitLab:
-			// vals+gitlab://project/variable#key
-			p := gitlab.New(conf)
-			return p, nil
-		case ProviderSSM:
-			// ref+awsssm://foo/bar?region=ap-northeast-1#/baz
-			// 1. GetParametersByPath for the prefix /foo/bar
-			// 2. Then extracts the value for key baz(=/foo/bar/baz) from the result from step 1.
-			p := ssm.New(conf)
-			return p, nil
-		case ProviderSecretsManager:
-			// ref+awssecrets://foo/bar?region=ap-northeast-1#/baz
-			// 1. Get secret for key foo/bar, parse it as yaml
-			// 2. Then extracts the value for key baz) from the result from step 1.
-			p := awssecrets.New(conf)
-			return p, nil
-		case ProviderSOPS:
-			p := sops.New(conf)
-			return p, nil
-		case ProviderEcho:
-			p := echo.New(conf)
-			return p, nil
-		case ProviderFile:
-			p := file.New(conf)
-			return p, nil
-		case ProviderGCPSecretManager:
-			p := gcpsecrets.New(conf)
-			return p, nil
-		case ProviderGoogleSheets:
-			return googlesheets.New(conf), nil
-		case ProviderTFState:
-			p := tfstate.New(conf, "")
-			return p, nil
-		case ProviderTFStateGS:
-			p := tfstate.New(conf, "gs")
-			return p, nil
-		case ProviderTFStateS3:
-			p := tfstate.New(conf, "s3")
-			return p, nil
-		case ProviderTFStateAzureRM:
-			p := tfstate.New(conf, "azurerm")
-			return p, nil
-		case ProviderTFStateRemote:
-			p := tfstate.New(conf, "remote")
-			return p, nil
-		case ProviderAzureKeyVault:
-			p := azurekeyvault.New(conf)
-			return p, nil
-		case ProviderKms:
-			p := awskms.New(conf)
-			return p, nil
-		case ProviderEnvSubst:
-			p := envsubst.New(conf)
-			return p, nil
-		}
-		return nil, fmt.Errorf("no provider registered for scheme %q", scheme)
-	}
-
-	updateProviders := func(uri *url.URL, hash string) (api.Provider, error) {
-		r.m.Lock()
-		defer r.m.Unlock()
-		p, ok := r.providers[hash]
-		if !ok {
-			var scheme string
-			scheme = uri.Scheme
-			scheme = strings.Split(scheme, "://")[0]
+		return providers.New(scheme, conf)
+	}
 
-			p, err = createProvider(scheme, uri)
-			if err != nil {
-				return nil, err
-			}
+	var scheme string
+	scheme = uri.Scheme
+	scheme = strings.Split(scheme, "://")[0]
+
+	hash := uriToProviderHash(scheme, query)
 
-			r.providers[hash] = p
+	r.m.Lock()
+	defer r.m.Unlock()
+	p, ok := r.providers[hash]
+	if !ok {
+		var err error
+		p, err = createProvider(scheme, query)
+		if err != nil {
+			return nil, err
 		}
-		return p, nil
-	}
 
-	var only []string
-	if r.Options.ExcludeSecret {
-		only = []string{"ref"}
+		r.providers[hash] = p
 	}
+	return p, nil
+}
 
-	expand := expansion.ExpandRegexMatch{
-		Only:   only,
-		Target: expansion.DefaultRefRegexp,
-		Lookup: func(key string) (interface{}, error) {
-			if val, ok := r.strCache.Get(key); ok {
-				return val, nil
-			}
+// lookupFunc returns the function to get the value for a ref, which gives up once ctx is done
+func (r *Runtime) lookupFunc(ctx context.Context) func(string) (interface{}, error) {
+	lookupRef := func(key string) (interface{}, error) {
+		uri, err := url.Parse(key)
+		if err != nil {
+			return "", err
+		}
 
-			uri, err := url.Parse(key)
-			if err != nil {
+		query := uri.Query()
+
+		if r.Options.CheckRef != nil {
+			if err := r.Options.CheckRef(uri.Scheme, query); err != nil {
 				return "", err
 			}
+		}
 
-			hash := uriToProviderHash(uri)
-
-			p, err := updateProviders(uri, hash)
-
+		ctx := ctx
+		if timeout := query.Get(ParamTimeout); timeout != "" {
+			d, err := time.ParseDuration(timeout)
 			if err != nil {
-				return "", err
+				return "", fmt.Errorf("invalid %s %q: %v", ParamTimeout, timeout, err)
 			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+		for _, param := range []string{ParamTimeout, ParamDefault, ParamOptional} {
+			if !isProviderParam(uri.Scheme, param) {
+				query.Del(param)
+			}
+		}
 
-			var frag string
-			frag = uri.Fragment
-			frag = strings.TrimPrefix(frag, "#")
-			frag = strings.TrimPrefix(frag, "/")
-
-			var components []string
-			var host string
+		// The values are cached and deduplicated regardless of the parameters of vals, which don't change them
+		uri.RawQuery = query.Encode()
+		key = uri.String()
 
-			{
-				host = uri.Host
+		if val, ok := r.strCache.Get(key); ok {
+			return val, nil
+		}
 
-				if host != "" {
-					components = append(components, host)
-				}
-			}
+		p, err := r.providerFor(uri, query)
 
-			{
-				path2 := uri.Path
-				path2 = strings.TrimPrefix(path2, "#")
-				if host != "" {
-					path2 = strings.TrimPrefix(path2, "/")
-				}
+		if err != nil {
+			return "", err
+		}
 
-				if path2 != "" {
-					components = append(components, path2)
-				}
-			}
+		var frag string
+		frag = uri.Fragment
+		frag = strings.TrimPrefix(frag, "#")
+		frag = strings.TrimPrefix(frag, "/")
 
-			path := strings.Join(components, "/")
+		path := refPath(uri)
 
-			if len(frag) == 0 {
-				var str string
-				cacheKey := key
-				if cachedStr, ok := r.strCache.Get(cacheKey); ok {
-					str, ok = cachedStr.(string)
-					if !ok {
-						return "", fmt.Errorf("error reading str from cache: unsupported value type %T", cachedStr)
-					}
-				} else {
-					str, err = p.GetString(path)
+		if len(frag) == 0 {
+			var str string
+			cacheKey := key
+			if cachedStr, ok := r.strCache.Get(cacheKey); ok {
+				str, ok = cachedStr.(string)
+				if !ok {
+					return "", fmt.Errorf("error reading str from cache: unsupported value type %T", cachedStr)
+				}
+			} else {
+				v, err := r.inflight.Do(cacheKey, func() (interface{}, error) {
+					str, err := api.GetString(ctx, p, path)
 					if err != nil {
-						return "", err
+						return nil, err
 					}
 					r.strCache.Add(cacheKey, str)
+					return str, nil
+				})
+				if err != nil {
+					return "", err
 				}
+				str = v.(string)
+			}
 
-				return str, nil
+			return str, nil
+		} else {
+			mapRequestURI := key[:strings.Index(key, "#")]
+			var obj map[string]interface{}
+			if cachedMap, ok := r.docCache.Get(mapRequestURI); ok {
+				obj, ok = cachedMap.(map[string]interface{})
+				if !ok {
+					return "", fmt.Errorf("error reading map from cache: unsupported value type %T", cachedMap)
+				}
 			} else {
-				mapRequestURI := key[:strings.LastIndex(key, uri.Fragment)-1]
-				var obj map[string]interface{}
-				if cachedMap, ok := r.docCache.Get(mapRequestURI); ok {
-					obj, ok = cachedMap.(map[string]interface{})
-					if !ok {
-						return "", fmt.Errorf("error reading map from cache: unsupported value type %T", cachedMap)
-					}
-				} else {
-					obj, err = p.GetStringMap(path)
+				v, err := r.inflight.Do(mapRequestURI, func() (interface{}, error) {
+					obj, err := api.GetStringMap(ctx, p, path)
 					if err != nil {
-						return "", err
+						return nil, err
 					}
 					r.docCache.Add(mapRequestURI, obj)
+					return obj, nil
+				})
+				if err != nil {
+					return "", err
 				}
+				obj = v.(map[string]interface{})
+			}
 
-				keys := strings.Split(frag, "/")
-				for i, k := range keys {
-					if k == "*" {
-						if i != len(keys)-1 {
-							return nil, fmt.Errorf("star can only be used at the end of fragment")
-						}
-						r.docCache.Add(key, obj)
-						return obj, nil
-					}
-					newobj := map[string]interface{}{}
-					switch t := obj[k].(type) {
-					case string:
-						if i != len(keys)-1 {
-							return "", fmt.Errorf("unexpected type of value for key at %d=%s in %v: expected map[string]interface{}, got %v(%T)", i, k, keys, t, t)
-						}
-						r.docCache.Add(key, t)
-						return t, nil
-					case map[string]interface{}:
-						newobj = t
-					case map[interface{}]interface{}:
-						for k, v := range t {
-							newobj[fmt.Sprintf("%v", k)] = v
-						}
-					}
-					obj = newobj
+			var v interface{}
+			if expr := strings.TrimPrefix(frag, FragmentJMESPath); expr != frag {
+				v, err = queryJMESPath(obj, expr)
+			} else {
+				v, err = valueAtFragment(obj, frag, r.Options.RedactErrors)
+			}
+			if err != nil {
+				return nil, err
+			}
+			r.docCache.Add(key, v)
+			return v, nil
+		}
+	}
+
+	lookupSource := func(source string) (interface{}, error) {
+		ref, calls := transforms.Split(source)
+		v, err := lookupRef(ref)
+		if err != nil {
+			if def, ok := defaultValue(ref); ok {
+				r.debugf(def, "vals: using the default value for %s as it failed: %v", ref, err)
+				return def, nil
+			}
+			if errors.Is(err, api.ErrNotFound) {
+				if v, ok, perr := optionalValue(ref); perr != nil {
+					return nil, perr
+				} else if ok {
+					return v, nil
 				}
+			}
+			return nil, err
+		}
+		if len(calls) == 0 {
+			return v, nil
+		}
+		return transforms.Apply(v, calls)
+	}
+
+	return func(key string) (interface{}, error) {
+		sources := splitFallbacks(key)
 
-				return "", fmt.Errorf("no value found for key %s", frag)
+		fallbackErr := &fallbackError{}
+		for i, source := range sources {
+			if lit, ok := parseLiteral(source); ok {
+				r.debugf(lit, "vals: using the literal %s for %s as all the refs failed", source, sources[0])
+				return lit, nil
 			}
-		},
+
+			v, err := lookupSource(source)
+			if err == nil {
+				if i > 0 {
+					r.debugf(v, "vals: using the value of the fallback %s for %s", source, sources[0])
+				}
+				return v, nil
+			}
+
+			if len(sources) == 1 {
+				return nil, err
+			}
+			fallbackErr.sources = append(fallbackErr.sources, source)
+			fallbackErr.errs = append(fallbackErr.errs, err)
+		}
+
+		return nil, fallbackErr
 	}
+}
+
+// debugf reports the source of the value v to Options.LogOutput, if any.
+// The message is redacted with Options.Redactor, which records v beforehand, as defaults and literals
+// appear in the refs mentioned by the message.
+func (r *Runtime) debugf(v interface{}, msg string, args ...interface{}) {
+	w := r.Options.LogOutput
+	if w == nil {
+		return
+	}
+	s := fmt.Sprintf(msg, args...)
+	if r.Options.Redactor != nil {
+		r.Options.Redactor.Add(v)
+		s = r.Options.Redactor.Redact(s)
+	}
+	fmt.Fprintln(w, s)
+}
+
+// valueAtFragment returns the value at the slash-separated path in the document, like `servers/0/host`.
+// Path components index into lists, and a trailing `*` returns the whole map at the path.
+// The value must be either a map selected by `*`, a string, or another scalar like a number, a boolean and null.
+// Values in the document are described in errors only unless hideValues is set.
+func valueAtFragment(doc map[string]interface{}, frag string, hideValues bool) (interface{}, error) {
+	keys := strings.Split(frag, "/")
+
+	var obj interface{} = doc
+	for i, k := range keys {
+		if m, ok := obj.(map[interface{}]interface{}); ok {
+			newobj := map[string]interface{}{}
+			for k, v := range m {
+				newobj[fmt.Sprintf("%v", k)] = v
+			}
+			obj = newobj
+		}
 
-	ret, err := expand.InMap(template)
+		if k == "*" {
+			if i != len(keys)-1 {
+				return nil, fmt.Errorf("star can only be used at the end of fragment")
+			}
+			m, ok := obj.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("unexpected type of value for star in %v: expected map[string]interface{}, got %s", keys, describeValue(obj, hideValues))
+			}
+			return m, nil
+		}
+
+		switch t := obj.(type) {
+		case map[string]interface{}:
+			v, ok := t[k]
+			if !ok {
+				return nil, api.NotFound(fmt.Errorf("no value found for key %s", frag))
+			}
+			obj = v
+		case []interface{}:
+			idx, err := strconv.Atoi(k)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected key at %d=%s in %v: expected an index of a list", i, k, keys)
+			}
+			if idx < 0 || idx >= len(t) {
+				return nil, api.NotFound(fmt.Errorf("index at %d=%s in %v is out of range: the list has %d items", i, k, keys, len(t)))
+			}
+			obj = t[idx]
+		default:
+			return nil, fmt.Errorf("unexpected type of value for key at %d=%s in %v: expected map[string]interface{} or []interface{}, got %s", i, k, keys, describeValue(t, hideValues))
+		}
+	}
+
+	switch obj.(type) {
+	case map[string]interface{}, map[interface{}]interface{}:
+		return nil, fmt.Errorf("no value found for key %s: the value is a map, use %s/* to get the whole map", frag, frag)
+	case []interface{}:
+		return nil, fmt.Errorf("no value found for key %s: the value is a list", frag)
+	}
+
+	return obj, nil
+}
+
+// refPath returns the path passed to the provider for the ref URI, which is made of the host and the path of the URI
+func refPath(uri *url.URL) string {
+	var components []string
+	var host string
+
+	{
+		host = uri.Host
+
+		if host != "" {
+			components = append(components, host)
+		}
+	}
+
+	{
+		path2 := uri.Path
+		path2 = strings.TrimPrefix(path2, "#")
+		if host != "" {
+			path2 = strings.TrimPrefix(path2, "/")
+		}
+
+		if path2 != "" {
+			components = append(components, path2)
+		}
+	}
+
+	return strings.Join(components, "/")
+}
+
+// queryJMESPath returns the result of the JMESPath expression against the document.
+// As with valueAtFragment, the result must be either a map, a string, or another scalar.
+func queryJMESPath(doc map[string]interface{}, expr string) (interface{}, error) {
+	// Round-trip through JSON so that the document consists only of the types supported by go-jmespath,
+	// like map[string]interface{} instead of map[interface{}]interface{} and float64 for all numbers
+	bs, err := json.Marshal(transforms.ToJSONCompatible(doc))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("converting document for jmespath: %w", err)
+	}
+	var data interface{}
+	if err := json.Unmarshal(bs, &data); err != nil {
+		return nil, fmt.Errorf("converting document for jmespath: %w", err)
 	}
 
-	return ret, nil
+	v, err := jmespath.Search(expr, data)
+	if err != nil {
+		return nil, fmt.Errorf("evaluating jmespath expression %q: %w", expr, err)
+	}
+
+	switch typed := v.(type) {
+	case nil:
+		return nil, api.NotFound(fmt.Errorf("no value found for jmespath expression %q", expr))
+	case []interface{}:
+		return nil, fmt.Errorf("jmespath expression %q resulted in a list: select an item, e.g. with `| [0]`", expr)
+	case float64:
+		// Integers are returned as ints like valueAtFragment does
+		if typed == math.Trunc(typed) && math.Abs(typed) < 1<<53 {
+			return int(typed), nil
+		}
+	}
+
+	return v, nil
+}
+
+type lookupResult struct {
+	val interface{}
+	err error
+}
+
+// prefetch looks up the distinct refs in parallel, using up to Options.Concurrency goroutines.
+// Failures are recorded in the results so that they are reported in the order of evaluation.
+func (r *Runtime) prefetch(refs []string, lookup func(string) (interface{}, error)) map[string]lookupResult {
+	concurrency := r.Options.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultConcurrency
+	}
+
+	results := map[string]lookupResult{}
+	if concurrency < 2 {
+		return results
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		keys = make(chan string)
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				val, err := lookup(key)
+				mu.Lock()
+				results[key] = lookupResult{val: val, err: err}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	seen := map[string]bool{}
+	for _, key := range refs {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		keys <- key
+	}
+	close(keys)
+
+	wg.Wait()
+
+	return results
 }
 
 func cloneMap(m map[string]interface{}) map[string]interface{} {
@@ -412,30 +813,69 @@ func IgnorePrefix(p string) Option {
 type Options struct {
 	CacheSize     int
 	ExcludeSecret bool
+	// Concurrency is the maximum number of refs resolved in parallel.
+	// Defaults to 8 when zero. Set it to 1 to resolve refs one by one.
+	Concurrency int
+	// DiskCache, when set, persists the values obtained from providers across runs,
+	// in addition to the in-memory cache sized by CacheSize.
+	DiskCache *diskcache.Cache
+	// KeepGoing makes evaluations report all the refs that failed to evaluate in the returned *EvalError,
+	// instead of stopping at the first failure.
+	KeepGoing bool
+	// LogOutput is where the sources of values are reported when refs fall back to defaults or other refs.
+	// Nothing is reported when nil.
+	LogOutput io.Writer
+	// Redactor, when set, records the values obtained for refs, so that they can be redacted from the output.
+	Redactor *Redactor
+	// RedactErrors keeps the values obtained from providers out of error messages,
+	// which then contain placeholders like the ones of Redactor instead.
+	// The errors of the providers themselves, like the ones of parsing invalid documents, are returned as is.
+	RedactErrors bool
+	// CheckRef, when set, is called with the scheme and the query parameters of each ref before its provider is used,
+	// including the refs in the values obtained for other refs, and fails the ref when it returns an error
+	CheckRef func(scheme string, query url.Values) error
 }
 
 func Env(template map[string]interface{}) ([]string, error) {
-	m, err := Eval(template)
+	return EnvContext(context.Background(), template)
+}
+
+// EnvContext is Env that gives up getting values from providers once ctx is done
+func EnvContext(ctx context.Context, template map[string]interface{}, o ...Options) ([]string, error) {
+	m, err := EvalContext(ctx, template, o...)
 	if err != nil {
 		return nil, err
 	}
+	var hideValues bool
+	if len(o) > 0 {
+		hideValues = o[0].RedactErrors
+	}
 	var env []string
 	for k, v := range m {
 		switch s := v.(type) {
 		case string:
 			env = append(env, fmt.Sprintf("%s=%s", k, s))
+		case bool, int, int64, uint64, float64:
+			// e.g. ports obtained from refs into JSON or YAML documents
+			env = append(env, fmt.Sprintf("%s=%s", k, expansion.FormatScalar(s)))
 		default:
-			return nil, fmt.Errorf("unexpected type of value: %v(%T)", v, v)
+			return nil, fmt.Errorf("unexpected type of value: %s", describeValue(v, hideValues))
 		}
 	}
 	return env, nil
 }
 
 func Exec(template map[string]interface{}, args []string) error {
+	return ExecContext(context.Background(), template, args)
+}
+
+// ExecContext is Exec that gives up getting values from providers once ctx is done.
+// ctx doesn't affect the executed command.
+func ExecContext(ctx context.Context, template map[string]interface{}, args []string, o ...Options) error {
 	if len(args) == 0 {
 		return errors.New("missing args")
 	}
-	env, err := Env(template)
+	env, err := EnvContext(ctx, template, o...)
 	if err != nil {
 		return err
 	}
@@ -448,6 +888,11 @@ func Exec(template map[string]interface{}, args []string) error {
 }
 
 func Eval(template map[string]interface{}, o ...Options) (map[string]interface{}, error) {
+	return EvalContext(context.Background(), template, o...)
+}
+
+// EvalContext is Eval that gives up getting values from providers once ctx is done
+func EvalContext(ctx context.Context, template map[string]interface{}, o ...Options) (map[string]interface{}, error) {
 	opts := Options{}
 	if len(o) > 0 {
 		opts = o[0]
@@ -456,7 +901,7 @@ func Eval(template map[string]interface{}, o ...Options) (map[string]interface{}
 	if err != nil {
 		return nil, err
 	}
-	return runtime.Eval(template)
+	return runtime.EvalContext(ctx, template)
 }
 
 func Load(conf api.StaticConfig, opt ...Option) (map[string]interface{}, error) {
@@ -0,0 +1,642 @@
+package vals
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/kroonprins/vals/pkg/api"
+)
+
+// blockingProvider never returns a value until the context is done
+type blockingProvider struct{}
+
+func (p *blockingProvider) GetString(key string) (string, error) {
+	return p.GetStringContext(context.Background(), key)
+}
+
+func (p *blockingProvider) GetStringContext(ctx context.Context, key string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func (p *blockingProvider) GetStringMap(key string) (map[string]interface{}, error) {
+	return p.GetStringMapContext(context.Background(), key)
+}
+
+func (p *blockingProvider) GetStringMapContext(ctx context.Context, key string) (map[string]interface{}, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// countingProvider returns a document with keys k0...k9 after a delay and counts the calls
+type countingProvider struct {
+	calls int32
+}
+
+func (p *countingProvider) GetString(key string) (string, error) {
+	atomic.AddInt32(&p.calls, 1)
+	time.Sleep(10 * time.Millisecond)
+	return key, nil
+}
+
+func (p *countingProvider) GetStringMap(key string) (map[string]interface{}, error) {
+	atomic.AddInt32(&p.calls, 1)
+	time.Sleep(10 * time.Millisecond)
+	m := map[string]interface{}{}
+	for i := 0; i < 10; i++ {
+		m[fmt.Sprintf("k%d", i)] = fmt.Sprintf("%s-%d", key, i)
+	}
+	return m, nil
+}
+
+var testCounting = &countingProvider{}
+
+var errTestFailing = errors.New("not found")
+
+// failingProvider fails to get any value
+type failingProvider struct{}
+
+func (p *failingProvider) GetString(key string) (string, error) {
+	return "", errTestFailing
+}
+
+func (p *failingProvider) GetStringMap(key string) (map[string]interface{}, error) {
+	return nil, errTestFailing
+}
+
+// optionalProvider fails with api.ErrNotFound unless its own `optional` parameter is set,
+// like the providers that define parameters of the same names as the ones of vals
+type optionalProvider struct {
+	optional string
+}
+
+func (p *optionalProvider) GetString(key string) (string, error) {
+	if p.optional == "" {
+		return "", api.NotFound(errTestFailing)
+	}
+	return "optional=" + p.optional, nil
+}
+
+func (p *optionalProvider) GetStringMap(key string) (map[string]interface{}, error) {
+	return nil, api.NotFound(errTestFailing)
+}
+
+// closingProvider counts the calls to Close, like the providers that hold resources such as plugins
+type closingProvider struct {
+	closes int32
+}
+
+func (p *closingProvider) GetString(key string) (string, error) {
+	return key, nil
+}
+
+func (p *closingProvider) GetStringMap(key string) (map[string]interface{}, error) {
+	return map[string]interface{}{"key": key}, nil
+}
+
+func (p *closingProvider) Close() error {
+	atomic.AddInt32(&p.closes, 1)
+	return nil
+}
+
+var testClosing = &closingProvider{}
+
+func init() {
+	RegisterProvider("testclosing", func(cfg api.StaticConfig) (api.Provider, error) {
+		return testClosing, nil
+	})
+	RegisterProvider("testoptional", func(cfg api.StaticConfig) (api.Provider, error) {
+		return &optionalProvider{optional: cfg.String(ParamOptional)}, nil
+	})
+	RegisterProvider("testblocking", func(cfg api.StaticConfig) (api.Provider, error) {
+		return &blockingProvider{}, nil
+	})
+	RegisterProvider("testcounting", func(cfg api.StaticConfig) (api.Provider, error) {
+		return testCounting, nil
+	})
+	RegisterProvider("testfailing", func(cfg api.StaticConfig) (api.Provider, error) {
+		return &failingProvider{}, nil
+	})
+}
+
+func TestEval_CheckRef(t *testing.T) {
+	errDenied := errors.New("denied")
+
+	runtime, err := New(Options{
+		CheckRef: func(scheme string, query url.Values) error {
+			if scheme == "file" {
+				return errDenied
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runtime.Eval(map[string]interface{}{"foo": "ref+echo://foo"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The refs in the values of other refs are checked too
+	_, err = runtime.Eval(map[string]interface{}{"foo": "ref+echo://h/a: ref%2Bfile:%2F%2F%2Fetc%2Fhostname|yamldec"})
+	if !errors.Is(err, errDenied) {
+		t.Errorf("expected the error of CheckRef, got %v", err)
+	}
+}
+
+func TestRuntime_Close(t *testing.T) {
+	runtime, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runtime.Eval(map[string]interface{}{"foo": "ref+testclosing://foo", "bar": "ref+echo://bar"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := runtime.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if closes := atomic.LoadInt32(&testClosing.closes); closes != 1 {
+		t.Errorf("unexpected number of closes: expected 1, got %d", closes)
+	}
+
+	// The providers are closed only once
+	if err := runtime.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if closes := atomic.LoadInt32(&testClosing.closes); closes != 1 {
+		t.Errorf("unexpected number of closes after closing again: expected 1, got %d", closes)
+	}
+}
+
+func TestEvalContext_Timeout(t *testing.T) {
+	testcases := []struct {
+		name     string
+		template map[string]interface{}
+		timeout  time.Duration
+	}{
+		{
+			name:     "context deadline",
+			template: map[string]interface{}{"foo": "ref+testblocking://foo/bar"},
+			timeout:  50 * time.Millisecond,
+		},
+		{
+			name:     "per-ref timeout",
+			template: map[string]interface{}{"foo": "ref+testblocking://foo/bar?timeout=50ms#/baz"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+
+			_, err := EvalContext(ctx, tc.template)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("unexpected error: expected %v, got %v", context.DeadlineExceeded, err)
+			}
+		})
+	}
+}
+
+func TestEvalContext_InvalidTimeout(t *testing.T) {
+	_, err := Eval(map[string]interface{}{"foo": "ref+echo://foo/bar?timeout=forever"})
+	if err == nil {
+		t.Fatal("expected error for an invalid timeout")
+	}
+}
+
+func TestEval_Concurrency(t *testing.T) {
+	for _, concurrency := range []int{1, 4, 32} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			atomic.StoreInt32(&testCounting.calls, 0)
+
+			template := map[string]interface{}{}
+			expected := map[string]interface{}{}
+			for i := 0; i < 10; i++ {
+				template[fmt.Sprintf("doc%d", i)] = fmt.Sprintf("ref+testcounting://doc#/k%d", i)
+				expected[fmt.Sprintf("doc%d", i)] = fmt.Sprintf("doc-%d", i)
+				template[fmt.Sprintf("str%d", i)] = fmt.Sprintf("ref+testcounting://str/%d", i)
+				expected[fmt.Sprintf("str%d", i)] = fmt.Sprintf("str/%d", i)
+			}
+			template["list"] = []interface{}{"ref+testcounting://str/0", "ref+testcounting://doc#/k0"}
+			expected["list"] = []interface{}{"str/0", "doc-0"}
+			// The parameters of vals don't make the values fetched again
+			template["params"] = []interface{}{"ref+testcounting://str/1?timeout=10s", "ref+testcounting://doc?default=x#/k1", "ref+testcounting://doc?optional=true&default=y#/k2"}
+			expected["params"] = []interface{}{"str/1", "doc-1", "doc-2"}
+
+			actual, err := Eval(template, Options{Concurrency: concurrency})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+			}
+
+			// one call for the document, and one for each string
+			if calls := atomic.LoadInt32(&testCounting.calls); calls != 11 {
+				t.Errorf("unexpected number of provider calls: expected=11, got=%d", calls)
+			}
+		})
+	}
+}
+
+func TestEvalNodes_Errors(t *testing.T) {
+	input := `foo: ref+echo://foo
+bar:
+  baz: ref+testfailing://bar/baz
+---
+list:
+- ok
+- ref+testfailing://list/1
+ref+testfailing://key: value
+`
+
+	testcases := []struct {
+		name      string
+		keepGoing bool
+		expected  []RefError
+	}{
+		{
+			name: "first failure",
+			expected: []RefError{
+				{Document: 0, Path: "bar.baz", Line: 3, Column: 8, Ref: "testfailing://bar/baz", Err: errTestFailing},
+			},
+		},
+		{
+			name:      "keep going",
+			keepGoing: true,
+			expected: []RefError{
+				{Document: 0, Path: "bar.baz", Line: 3, Column: 8, Ref: "testfailing://bar/baz", Err: errTestFailing},
+				{Document: 1, Path: "list[1]", Line: 7, Column: 3, Ref: "testfailing://list/1", Err: errTestFailing},
+				{Document: 1, Path: `["ref+testfailing://key"]`, Line: 8, Column: 1, Ref: "testfailing://key", Err: errTestFailing},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			nodes, err := nodesFromReader(strings.NewReader(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			runtime, err := New(Options{KeepGoing: tc.keepGoing})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = runtime.EvalNodes(context.Background(), nodes)
+
+			var evalErr *EvalError
+			if !errors.As(err, &evalErr) {
+				t.Fatalf("unexpected error: expected *EvalError, got %T: %v", err, err)
+			}
+
+			var actual []RefError
+			for _, e := range evalErr.Errors {
+				actual = append(actual, *e)
+			}
+
+			if diff := cmp.Diff(tc.expected, actual, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("unexpected errors: -(expected), +(got)\n%s", diff)
+			}
+
+			if !errors.Is(err, errTestFailing) {
+				t.Errorf("expected the error to wrap %v", errTestFailing)
+			}
+		})
+	}
+}
+
+func TestRefError_Error(t *testing.T) {
+	testcases := []struct {
+		err      RefError
+		expected string
+	}{
+		{
+			err:      RefError{Document: 1, Path: "foo.bar", Line: 3, Column: 8, Ref: "echo://foo", Err: errTestFailing},
+			expected: "foo.bar (document 1, line 3, column 8): expand echo://foo: " + errTestFailing.Error(),
+		},
+		{
+			err:      RefError{Line: 3, Column: 8, Ref: "echo://foo", Err: errTestFailing},
+			expected: "line 3, column 8: expand echo://foo: " + errTestFailing.Error(),
+		},
+		{
+			err:      RefError{Path: "foo.bar", Ref: "echo://foo", Err: errTestFailing},
+			expected: "foo.bar: expand echo://foo: " + errTestFailing.Error(),
+		},
+		{
+			err:      RefError{Ref: "echo://foo", Err: errTestFailing},
+			expected: "expand echo://foo: " + errTestFailing.Error(),
+		},
+	}
+
+	for _, tc := range testcases {
+		if actual := tc.err.Error(); actual != tc.expected {
+			t.Errorf("unexpected error message: expected=%q, got=%q", tc.expected, actual)
+		}
+	}
+}
+
+func TestEvalNodes(t *testing.T) {
+	input := `foo: ref+echo://foo/bar
+---
+- ignored: true
+`
+	nodes, err := nodesFromReader(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runtime.EvalNodes(context.Background(), nodes); err == nil || !strings.HasPrefix(err.Error(), "document 1: ") {
+		t.Errorf("unexpected error for a non-map document: %v", err)
+	}
+
+	res, err := runtime.EvalNodes(context.Background(), nodes[:1])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var actual map[string]interface{}
+	if err := res[0].Decode(&actual); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(map[string]interface{}{"foo": "foo/bar"}, actual); diff != "" {
+		t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+	}
+}
+
+func TestEval_ErrorPath(t *testing.T) {
+	_, err := Eval(map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": []interface{}{"ref+testfailing://x"},
+		},
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	expected := "a.b[0]: expand testfailing://x: not found"
+	if err.Error() != expected {
+		t.Errorf("unexpected error: expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestEval_Fragments(t *testing.T) {
+	doc := `{"servers": [{"host": "a.example.com", "port": 8080}, {"host": "b.example.com", "tls": true}], "replicas": null, "nested": {"key": "value"}}`
+	path := filepath.Join(t.TempDir(), "doc.json")
+	if err := os.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ref := func(frag string) string {
+		return fmt.Sprintf("ref+file://%s#%s", path, frag)
+	}
+
+	actual, err := Eval(map[string]interface{}{
+		"host":     ref("/servers/0/host"),
+		"port":     ref("/servers/0/port"),
+		"tls":      ref("/servers/1/tls"),
+		"replicas": ref("/replicas"),
+		"url":      "https://" + ref("/servers/1/host") + "+:" + ref("/servers/0/port"),
+		"nested":   ref("/nested/*"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"host":     "a.example.com",
+		"port":     8080,
+		"tls":      true,
+		"replicas": nil,
+		"url":      "https://b.example.com:8080",
+		"nested":   map[string]interface{}{"key": "value"},
+	}
+
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+	}
+
+	for _, frag := range []string{"/servers/2/host", "/servers/x/host", "/servers", "/nested", "/missing", "/servers/0/host/x"} {
+		if _, err := Eval(map[string]interface{}{"v": ref(frag)}); err == nil {
+			t.Errorf("expected error for %s", frag)
+		}
+	}
+}
+
+func TestEval_JMESPath(t *testing.T) {
+	doc := `users:
+- name: alice
+  role: admin
+  password: alice-pw
+- name: bob
+  role: dev
+  password: bob-pw
+settings:
+  port: 8080
+  ratio: 0.5
+  flags: {debug: true}
+`
+	path := filepath.Join(t.TempDir(), "doc.yaml")
+	if err := os.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ref := func(expr string) string {
+		return fmt.Sprintf("ref+file://%s#jmespath=%s", path, expr)
+	}
+
+	actual, err := Eval(map[string]interface{}{
+		"admin":    ref("users[?role=='admin'].password | [0]"),
+		"port":     ref("settings.port"),
+		"ratio":    ref("settings.ratio"),
+		"flags":    ref("settings.flags"),
+		"count":    ref("length(users)"),
+		"combined": ref("users[1].name") + "+/" + ref("users[0].name"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"admin":    "alice-pw",
+		"port":     8080,
+		"ratio":    0.5,
+		"flags":    map[string]interface{}{"debug": true},
+		"count":    2,
+		"combined": "bob/alice",
+	}
+
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+	}
+
+	for _, expr := range []string{"users[?role=='none'] | [0]", "users[*].name", "users[?"} {
+		if _, err := Eval(map[string]interface{}{"v": ref(expr)}); err == nil {
+			t.Errorf("expected error for %s", expr)
+		}
+	}
+}
+
+func TestEval_Transforms(t *testing.T) {
+	RegisterTransform("testsuffix", func(v interface{}, args ...string) (interface{}, error) {
+		return fmt.Sprintf("%v%s", v, strings.Join(args, "")), nil
+	})
+
+	actual, err := Eval(map[string]interface{}{
+		"decoded":  "ref+echo://Zm9v|b64dec",
+		"pipeline": "ref+echo://Zm9v|b64dec|upper|testsuffix -x",
+		"concat":   "ref+echo://Zm9v|b64dec+-ref+echo://bar|upper",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"decoded":  "foo",
+		"pipeline": "FOO-x",
+		"concat":   "foo-BAR",
+	}
+
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+	}
+
+	_, err = Eval(map[string]interface{}{"v": "ref+echo://!!|b64dec"})
+	if err == nil || !strings.Contains(err.Error(), "transform b64dec") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestEval_Optional(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "secrets.yaml")
+	if err := os.WriteFile(file, []byte("foo: FOO\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	input := map[string]interface{}{
+		"found":   fmt.Sprintf("ref?+file://%s#/foo", file),
+		"missing": fmt.Sprintf("ref?+file://%s#/bar", file),
+		"nofile":  fmt.Sprintf("ref?+file://%s/missing.yaml", dir),
+		"param":   fmt.Sprintf("ref+file://%s?optional=true#/bar", file),
+		"null":    fmt.Sprintf("ref+file://%s?optional=null#/bar", file),
+		"list":    []interface{}{fmt.Sprintf("ref?+file://%s#/bar", file), "item"},
+	}
+
+	actual, err := Eval(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"found": "FOO",
+		"null":  nil,
+		"list":  []interface{}{"item"},
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+	}
+
+	// Only the missing secrets are omitted, and the other failures are still errors
+	_, err = Eval(map[string]interface{}{"v": "ref?+testfailing://x"})
+	if !errors.Is(err, errTestFailing) {
+		t.Errorf("unexpected error for the failing optional ref: %v", err)
+	}
+
+	_, err = Eval(map[string]interface{}{"v": fmt.Sprintf("ref+file://%s#/bar", file)})
+	if !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("unexpected error for the missing required ref: %v", err)
+	}
+
+	_, err = Eval(map[string]interface{}{"v": fmt.Sprintf("ref+file://%s?optional=maybe#/bar", file)})
+	if err == nil || !strings.Contains(err.Error(), `invalid optional "maybe"`) {
+		t.Errorf("unexpected error for the invalid optional: %v", err)
+	}
+}
+
+func TestEval_ProviderParams(t *testing.T) {
+	providerParams["testoptional"] = []string{ParamOptional}
+	defer delete(providerParams, "testoptional")
+
+	actual, err := Eval(map[string]interface{}{
+		"param":  "ref+testoptional://foo?optional=1",
+		"marker": "ref?+testoptional://foo",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The parameter is passed to the provider instead of making the ref optional,
+	// while the `?` marker still makes it optional
+	expected := map[string]interface{}{"param": "optional=1"}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+	}
+}
+
+func TestEval_Nested(t *testing.T) {
+	dir := t.TempDir()
+	paths := filepath.Join(dir, "paths.yaml")
+	secrets := filepath.Join(dir, "secrets.yaml")
+	if err := os.WriteFile(paths, []byte(fmt.Sprintf("secrets: %s\n", secrets)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(secrets, []byte("db:\n  password: PW\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, concurrency := range []int{1, 4} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			runtime, err := New(Options{Concurrency: concurrency})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual, err := runtime.Eval(map[string]interface{}{
+				"password": fmt.Sprintf("ref+file://{{ref+file://%s#/secrets}}#/db/password", paths),
+				"missing":  fmt.Sprintf("ref?+file://{{ref?+file://%s#/missing}}#/db/password", paths),
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expected := map[string]interface{}{"password": "PW"}
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Errorf("unexpected result: -(expected), +(got)\n%s", diff)
+			}
+		})
+	}
+
+	_, err := Eval(map[string]interface{}{"v": fmt.Sprintf("ref+file://{{ref+file://%s#/missing}}", paths)})
+	var refErr *RefError
+	if !errors.As(err, &refErr) || refErr.Path != "v" || !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}